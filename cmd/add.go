@@ -3,8 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/julienlevasseur/profiler/pkg/remote"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -47,6 +49,17 @@ var addCmd = &cobra.Command{
 				value = args[2]
 			}
 
+			if remote.IsRemoteProfile(profileName) {
+				if len(args) < 2 {
+					// The profile_name of `<remote>/<profile>` is <profile>:
+					value = profileName[strings.Index(profileName, "/")+1:]
+				}
+				printRemoteWrite(profileName, func() (string, error) {
+					return remote.SetVariable(profileName, key, value)
+				})
+				return
+			}
+
 			alreadyExist, _, err := profile.FoundInfFile(
 				filePath,
 				key,
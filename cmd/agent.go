@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julienlevasseur/profiler/pkg/agent"
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+const defaultAgentTTL = 15 * time.Minute
+
+var agentTTL time.Duration
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "run the agent keeping the remote profiles in memory, which `use` consults first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ttl := agentTTL
+		if ttl == 0 {
+			ttl = viper.GetDuration("agentTTL")
+		}
+		if ttl <= 0 {
+			ttl = defaultAgentTTL
+		}
+
+		socket := agent.SocketPath()
+		listener, err := agent.Listen(socket)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+
+		log.Printf("Agent listening on %s, keeping the profiles %s", socket, ttl)
+		err = agent.NewServer(ttl).Serve(listener)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var agentLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "lock the agent with a passphrase, dropping its profiles until unlocked",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := readPassphrase("Passphrase: ")
+		if err == nil && term.IsTerminal(int(os.Stdin.Fd())) {
+			var confirmation string
+			confirmation, err = readPassphrase("Confirm the passphrase: ")
+			if err == nil && confirmation != passphrase {
+				err = errors.New("The passphrases don't match")
+			}
+		}
+		if err == nil {
+			err = agent.Lock(passphrase)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Agent locked")
+	},
+}
+
+var agentUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "unlock the agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := readPassphrase("Passphrase: ")
+		if err == nil {
+			err = agent.Unlock(passphrase)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Agent unlocked")
+	},
+}
+
+var agentFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "drop every profile held by the agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := agent.Flush()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var agentStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "display the state of the agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := agent.GetStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No agent running on %s: %s\n", agent.SocketPath(), err)
+			os.Exit(1)
+		}
+
+		state := "unlocked"
+		if status.Locked {
+			state = "locked"
+		}
+		fmt.Printf("Agent %s, %d profile(s) held (%s)\n", state, status.Profiles, agent.SocketPath())
+	},
+}
+
+// readPassphrase read a passphrase from the terminal without echoing it, or
+// a line of stdin when it isn't a terminal
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		passphrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimRight(passphrase, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+
+	return string(passphrase), err
+}
+
+// agentForget drop from the agent the profile changed by a write subcommand
+// of the given backend, so its next use fetches it again
+func agentForget(backend string) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		switch cmd.Name() {
+		case "add", "remove", "rollback":
+		default:
+			return
+		}
+
+		if len(args) > 0 {
+			agent.Forget(backend + "/" + args[0])
+		}
+	}
+}
+
+func init() {
+	agentCmd.Flags().DurationVar(
+		&agentTTL,
+		"ttl",
+		0,
+		"how long the profiles are kept (agentTTL, 15m by default)",
+	)
+	agentCmd.AddCommand(agentLockCmd)
+	agentCmd.AddCommand(agentUnlockCmd)
+	agentCmd.AddCommand(agentFlushCmd)
+	agentCmd.AddCommand(agentStatusCmd)
+	RootCmd.AddCommand(agentCmd)
+
+	// The commands of the other backends are built with it:
+	ssmCmd.PersistentPostRun = agentForget(backend.SSM)
+	consulCmd.PersistentPostRun = agentForget(backend.Consul)
+}
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/spf13/cobra"
+)
+
+// backendCmd is the command of a registered backend with its add, list,
+// remove, show and use subcommands
+type backendCmd struct {
+	*cobra.Command
+	registration backend.Registration
+
+	add    *cobra.Command
+	list   *cobra.Command
+	remove *cobra.Command
+	show   *cobra.Command
+	use    *cobra.Command
+
+	addMeta   metaFlags
+	listTags  []string
+	removeYes bool
+}
+
+// registration return the registered backend of the given name, which must
+// exist
+func registration(name string) backend.Registration {
+	r, ok := backend.Get(name)
+	if !ok {
+		panic("unknown backend " + name)
+	}
+
+	return r
+}
+
+// describe return the description of the profiles of the backend, stored at
+// the given place (e.g. "in etcd")
+func describe(r backend.Registration, subject string, store string) string {
+	if r.Local {
+		return subject + " stored " + store
+	}
+
+	return "remote " + subject + " stored " + store
+}
+
+// newUseCmd build the `use` subcommand of the given backend
+func newUseCmd(r backend.Registration) *cobra.Command {
+	return &cobra.Command{
+		Use:               "use [profile_name]",
+		Short:             fmt.Sprintf("use the given %s profile", r.Title),
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeProfiles(backendProfiles(r), 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			profile.UseBackend(r, args[0])
+		},
+	}
+}
+
+// newBackendCmd build the command of the given registered backend, whose
+// profiles are stored at the given place (e.g. "in etcd")
+func newBackendCmd(name string, store string) *backendCmd {
+	r := registration(name)
+	b := &backendCmd{registration: r}
+
+	b.Command = &cobra.Command{
+		Use:   r.Name,
+		Short: "deal with " + describe(r, "profiles", store),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 || args[0] == "help" {
+				cmd.Help()
+				os.Exit(0)
+			}
+		},
+		PersistentPostRun: agentForget(r.Name),
+	}
+
+	b.add = &cobra.Command{
+		Use:               "add [profile_name] [ENV_VAR=value]",
+		Short:             fmt.Sprintf("add the given profile or the given env var to the %s profile", r.Title),
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeProfiles(backendProfiles(r), 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 2 { // If no value provided:
+				err := errors.New("Please provide a value for the variable")
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			// Just the name of profile has been provided, only create it if
+			// it doesn't exist to not write it again:
+			create := true
+			if len(args) == 1 {
+				profileExist, err := r.ProfileExist(args[0])
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				create = !profileExist
+			}
+
+			if create {
+				err := r.AddKVPair(args[0], args[1:])
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			if b.addMeta.isSet() || b.addMeta.keyDescription != "" {
+				var key string
+				if len(args) > 1 {
+					key = args[1]
+				}
+
+				m, err := r.GetMeta(args[0])
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				b.addMeta.apply(&m, key)
+
+				err = r.SetMeta(args[0], m)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+		},
+	}
+	if r.SetMeta != nil {
+		b.addMeta.register(b.add)
+	}
+
+	b.list = &cobra.Command{
+		Use:   "list",
+		Short: "list " + describe(r, "profiles", store),
+		Run: func(cmd *cobra.Command, args []string) {
+			profiles, err := r.ListProfiles()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			for _, p := range profiles {
+				if len(b.listTags) > 0 {
+					m, err := r.GetMeta(p)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+					if !m.HasTags(b.listTags) {
+						continue
+					}
+				}
+				fmt.Println(p)
+			}
+		},
+	}
+	if r.GetMeta != nil {
+		b.list.Flags().StringSliceVar(
+			&b.listTags,
+			"tag",
+			[]string{},
+			"only list the profiles having the given tag (can be repeated)",
+		)
+	}
+
+	b.remove = &cobra.Command{
+		Use:               "remove [profile_name] [ENV_VAR]",
+		Short:             "remove the given profile or the given env var from the " + describe(r, "profile", store),
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeProfileAndKey(backendProfiles(r), backendKeys(r)),
+		Run: func(cmd *cobra.Command, args []string) {
+			// Check first if the given profile exists
+			profileExist, err := r.ProfileExist(args[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if !profileExist {
+				err := errors.New("The provided Profile does not exist")
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if len(args) < 2 {
+				// Only the profile name provided, delete the whole profile:
+				if !b.removeYes && !confirm(fmt.Sprintf("Delete the %s profile?", args[0])) {
+					os.Exit(1)
+				}
+
+				err = r.DeleteProfile(args[0])
+			} else {
+				err = r.RemoveKVPair(args[0], args[1])
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+	b.remove.Flags().BoolVarP(
+		&b.removeYes,
+		"yes",
+		"y",
+		false,
+		"delete the whole profile without confirmation",
+	)
+
+	b.show = &cobra.Command{
+		Use:               "show [profile_name]",
+		Short:             "show given profile(s) variables name",
+		ValidArgsFunction: completeProfiles(backendProfiles(r), 0),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, p := range args {
+				vars, err := r.ShowProfile(p)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				var m meta.Meta
+				if r.GetMeta != nil {
+					m, err = r.GetMeta(p)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+				}
+
+				printProfile(p, vars, m, nil)
+			}
+		},
+	}
+
+	b.use = newUseCmd(r)
+
+	b.AddCommand(b.add)
+	b.AddCommand(b.list)
+	b.AddCommand(b.remove)
+	b.AddCommand(b.show)
+	b.AddCommand(b.use)
+
+	return b
+}
+
+var etcdCmd = newBackendCmd(backend.Etcd, "in etcd")
+var s3Cmd = newBackendCmd(backend.S3, "in S3")
+var passCmd = newBackendCmd(backend.Pass, "in the password store (pass)")
+var k8sCmd = newBackendCmd(backend.K8s, "in Kubernetes ConfigMaps and Secrets")
+var httpCmd = newBackendCmd(backend.HTTP, "on a profiler server")
+
+func init() {
+	k8sCmd.Aliases = []string{"kubernetes"}
+	k8sCmd.add.Short += " (requires k8sWritable)"
+	k8sCmd.remove.Short += " (requires k8sWritable)"
+
+	for _, c := range []*backendCmd{etcdCmd, s3Cmd, passCmd, k8sCmd, httpCmd} {
+		RootCmd.AddCommand(c.Command)
+	}
+}
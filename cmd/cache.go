@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/julienlevasseur/profiler/pkg/cache"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "deal with the offline cache of the remote profiles",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || args[0] == "help" {
+			cmd.Help()
+			os.Exit(0)
+		}
+	},
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the cached remote profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := cache.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		for _, e := range entries {
+			state := "expires " + e.Expires.Local().Format(time.RFC3339)
+			if e.Expired() {
+				state = "expired"
+			}
+
+			fmt.Printf(
+				"%s  %s  fetched %s, %s\n",
+				e.Name(),
+				e.Source,
+				e.Fetched.Local().Format(time.RFC3339),
+				state,
+			)
+		}
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [backend/profile...]",
+	Short: "remove the given profiles, or every profile, from the offline cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			err := cache.Clear()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		for _, name := range args {
+			backend, profileName, err := cache.ParseName(name)
+			if err == nil {
+				err = cache.Remove(backend, profileName)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	RootCmd.AddCommand(cacheCmd)
+}
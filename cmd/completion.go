@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/completion"
+	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/julienlevasseur/profiler/pkg/remote"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+func localProfiles() ([]string, error) {
+	var profiles []string
+	for _, file := range localProfileFiles() {
+		profiles = append(profiles, localProfileName(file))
+	}
+
+	// The profiles of the git remotes are local clones:
+	remoteProfiles, err := remote.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(profiles, remoteProfiles...), nil
+}
+
+func localKeys(profileName string) ([]string, error) {
+	if remote.IsRemoteProfile(profileName) {
+		file, err := remote.ProfileFile(profileName)
+		if err != nil {
+			return nil, nil
+		}
+
+		return profileKeys(file), nil
+	}
+
+	// The profile may be a .yml or a .yaml file:
+	for _, ext := range []string{".yml", ".yaml"} {
+		file := viper.GetString("profilesFolder") + "/." + profileName + ext
+		if profile.FileExist(file) {
+			return profileKeys(file), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// backendProfiles return the completion of the profiles of the given
+// backend, cached unless they are stored locally
+func backendProfiles(r backend.Registration) func() ([]string, error) {
+	if r.Local {
+		return r.ListProfiles
+	}
+
+	return func() ([]string, error) {
+		return completion.Cached(r.Name+"-profiles", r.Source(), r.ListProfiles)
+	}
+}
+
+// backendKeys return the completion of the variables of a profile of the
+// given backend, cached unless they are stored locally
+func backendKeys(r backend.Registration) func(string) ([]string, error) {
+	if r.Local {
+		return r.ShowProfile
+	}
+
+	return func(profileName string) ([]string, error) {
+		return completion.Cached(r.Name+"-keys-"+profileName, r.Source(), func() ([]string, error) {
+			return r.ShowProfile(profileName)
+		})
+	}
+}
+
+func remoteNames() ([]string, error) {
+	remotes, err := remote.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range remotes {
+		names = append(names, r.Name)
+	}
+
+	return names, nil
+}
+
+func filterCompletions(values []string, toComplete string) []string {
+	var completions []string
+	for _, v := range values {
+		if strings.HasPrefix(v, toComplete) {
+			completions = append(completions, v)
+		}
+	}
+	sort.Strings(completions)
+
+	return completions
+}
+
+// completeProfiles complete profile names for every argument (up to maxArgs
+// arguments when it's not 0)
+func completeProfiles(profiles func() ([]string, error), maxArgs int) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if maxArgs > 0 && len(args) >= maxArgs {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		values, err := profiles()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		return filterCompletions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeProfileAndKey complete the profile name as first argument and the
+// variables of this profile as second argument
+func completeProfileAndKey(profiles func() ([]string, error), keys func(string) ([]string, error)) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return completeProfiles(profiles, 1)(cmd, args, toComplete)
+		case 1:
+			values, err := keys(args[0])
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveError
+			}
+			return filterCompletions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+		default:
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+}
+
+func init() {
+	useCmd.ValidArgsFunction = completeProfiles(localProfiles, 1)
+	showCmd.ValidArgsFunction = completeProfiles(localProfiles, 0)
+	addCmd.ValidArgsFunction = completeProfiles(localProfiles, 1)
+	removeCmd.ValidArgsFunction = completeProfileAndKey(localProfiles, localKeys)
+
+	ssmProfiles := backendProfiles(registration(backend.SSM))
+	ssmAddCmd.ValidArgsFunction = completeProfiles(ssmProfiles, 1)
+	ssmShowCmd.ValidArgsFunction = completeProfiles(ssmProfiles, 0)
+	ssmRemoveCmd.ValidArgsFunction = completeProfileAndKey(ssmProfiles, backendKeys(registration(backend.SSM)))
+	ssmHistoryCmd.ValidArgsFunction = completeProfileAndKey(ssmProfiles, backendKeys(registration(backend.SSM)))
+	ssmRollbackCmd.ValidArgsFunction = completeProfiles(ssmProfiles, 1)
+
+	consulProfiles := backendProfiles(registration(backend.Consul))
+	consulAddCmd.ValidArgsFunction = completeProfiles(consulProfiles, 1)
+	consulShowCmd.ValidArgsFunction = completeProfiles(consulProfiles, 0)
+	consulMigrateCmd.ValidArgsFunction = completeProfiles(consulProfiles, 0)
+	consulRemoveCmd.ValidArgsFunction = completeProfileAndKey(consulProfiles, backendKeys(registration(backend.Consul)))
+
+	remoteRemoveCmd.ValidArgsFunction = completeProfiles(remoteNames, 1)
+	remoteUpdateCmd.ValidArgsFunction = completeProfiles(remoteNames, 1)
+
+	vaultHistoryCmd.ValidArgsFunction = completeProfiles(backendProfiles(vaultCmd.registration), 1)
+	vaultRollbackCmd.ValidArgsFunction = completeProfiles(backendProfiles(vaultCmd.registration), 1)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/julienlevasseur/profiler/pkg/backend"
 	"github.com/julienlevasseur/profiler/pkg/consul"
 	"github.com/spf13/cobra"
 )
@@ -20,6 +21,11 @@ var consulCmd = &cobra.Command{
 	},
 }
 
+var consulAddMeta metaFlags
+var consulListTags []string
+var consulRemoveYes bool
+var consulMigrateTo string
+
 var consulAddCmd = &cobra.Command{
 	Use:   "add [profile_name] [ENV_VAR=value]",
 	Short: "add the given profile or the given env var to the consul profile",
@@ -71,6 +77,27 @@ var consulAddCmd = &cobra.Command{
 				os.Exit(1)
 			}
 		}
+
+		if consulAddMeta.isSet() || consulAddMeta.keyDescription != "" {
+			var key string
+			if len(args) > 1 {
+				key = args[1]
+			}
+
+			m, err := consul.GetMeta(args[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			consulAddMeta.apply(&m, key)
+
+			err = consul.SetMeta(args[0], m)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
 	},
 }
 
@@ -86,6 +113,16 @@ var consulListCmd = &cobra.Command{
 		}
 
 		for _, p := range profiles {
+			if len(consulListTags) > 0 {
+				m, err := consul.GetMeta(p)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				if !m.HasTags(consulListTags) {
+					continue
+				}
+			}
 			fmt.Println(p)
 		}
 	},
@@ -108,27 +145,23 @@ var consulRemoveCmd = &cobra.Command{
 			os.Exit(1)
 		} else {
 			// check if a variable has been provided or just a profile name:
-			if len(args) < 3 {
-				// Only the profile name provided, delete all related params:
-				err := consul.DeleteKey("/profiler/" + args[0])
+			if len(args) < 2 {
+				// Only the profile name provided, delete the whole profile:
+				if !consulRemoveYes && !confirm(fmt.Sprintf("Delete the %s profile?", args[0])) {
+					os.Exit(1)
+				}
+
+				err := consul.DeleteProfile(args[0])
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
 				}
 			} else {
-				profile, err := consul.GetKVPair("/profiler/" + args[0])
+				err := consul.RemoveKVPair(args[0], args[1])
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
 				}
-				fmt.Println(profile)
-				//err := consul.DeleteKey(
-				//	"/profiler/" + args[1] + "/" + args[2],
-				//)
-				//if err != nil {
-				//	fmt.Fprintln(os.Stderr, err)
-				//	os.Exit(1)
-				//}
 			}
 		}
 	},
@@ -145,21 +178,92 @@ var consulShowCmd = &cobra.Command{
 				os.Exit(1)
 			}
 
-			// Display Profile's name:
-			fmt.Printf("%s:\n", p)
-			// Display each Profile's env var name:
-			for _, v := range vars {
-				fmt.Printf("- %s\n", v)
+			m, err := consul.GetMeta(p)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			printProfile(p, vars, m, nil)
+		}
+	},
+}
+
+var consulMigrateCmd = &cobra.Command{
+	Use:   "migrate [profile_name...] --to [blob|per-key]",
+	Short: "convert the given Consul profiles (every profile by default) to the given layout",
+	Run: func(cmd *cobra.Command, args []string) {
+		if consulMigrateTo == "" {
+			fmt.Fprintln(os.Stderr, errors.New("Please provide the layout to migrate to with --to"))
+			os.Exit(1)
+		}
+
+		profiles := args
+		if len(profiles) == 0 {
+			var err error
+			profiles, err = consul.ListProfiles()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		// Every profile is checked first, so that nothing is converted when
+		// one of them can't be:
+		failed := false
+		for _, p := range profiles {
+			err := consul.CheckMigration(p, consulMigrateTo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+		for _, p := range profiles {
+			migrated, err := consul.Migrate(p, consulMigrateTo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+				os.Exit(1)
+			}
+
+			if migrated {
+				fmt.Printf("%s: migrated to %s\n", p, consulMigrateTo)
+			} else {
+				fmt.Printf("%s: already %s\n", p, consulMigrateTo)
 			}
-			fmt.Printf("\n")
 		}
 	},
 }
 
 func init() {
+	consulAddMeta.register(consulAddCmd)
+	consulListCmd.Flags().StringSliceVar(
+		&consulListTags,
+		"tag",
+		[]string{},
+		"only list the profiles having the given tag (can be repeated)",
+	)
+	consulRemoveCmd.Flags().BoolVarP(
+		&consulRemoveYes,
+		"yes",
+		"y",
+		false,
+		"delete the whole profile without confirmation",
+	)
+	consulMigrateCmd.Flags().StringVar(
+		&consulMigrateTo,
+		"to",
+		"",
+		"layout to migrate to (blob or per-key)",
+	)
 	consulCmd.AddCommand(consulAddCmd)
 	consulCmd.AddCommand(consulListCmd)
+	consulCmd.AddCommand(consulMigrateCmd)
 	consulCmd.AddCommand(consulRemoveCmd)
 	consulCmd.AddCommand(consulShowCmd)
+	consulCmd.AddCommand(newUseCmd(registration(backend.Consul)))
 	RootCmd.AddCommand(consulCmd)
 }
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/consul"
+	"github.com/spf13/cobra"
+)
+
+var consulWatchCmd = &cobra.Command{
+	Use:   "watch [profile_name] [-- command [args...]]",
+	Short: "watch the given Consul profile and display its changes, optionally (re)starting a command with its variables",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profileName := args[0]
+
+		var command []string
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			command = args[dash:]
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		var child *exec.Cmd
+		err := consul.Watch(ctx, profileName, func(vars map[string]string, changes []consul.Change) {
+			if changes == nil {
+				fmt.Printf("[%s] watching %s\n", time.Now().Format(time.RFC3339), profileName)
+			} else {
+				fmt.Printf("[%s] %s changed:\n", time.Now().Format(time.RFC3339), profileName)
+				for _, c := range changes {
+					fmt.Printf("%s %s\n", c.Kind, c.Key)
+				}
+			}
+
+			if len(command) > 0 {
+				stopCommand(child)
+				child = startCommand(command, vars)
+			}
+		})
+
+		stopCommand(child)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// startCommand run the given command with the profile variables added to the
+// current environment
+func startCommand(command []string, vars map[string]string) *exec.Cmd {
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = os.Environ()
+	for k, v := range vars {
+		child.Env = append(child.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	err := child.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+
+	return child
+}
+
+// stopCommand terminate the given command, killing it if it doesn't exit
+// within 10 seconds
+func stopCommand(child *exec.Cmd) {
+	if child == nil || child.Process == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		child.Wait()
+		close(done)
+	}()
+
+	child.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		child.Process.Kill()
+		<-done
+	}
+}
+
+func init() {
+	consulWatchCmd.ValidArgsFunction = completeProfiles(backendProfiles(registration(backend.Consul)), 1)
+	consulCmd.AddCommand(consulWatchCmd)
+}
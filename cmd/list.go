@@ -5,63 +5,145 @@ import (
 	"log"
 	"strings"
 
-	"github.com/julienlevasseur/profiler/pkg/consul"
+	"github.com/julienlevasseur/profiler/pkg/backend"
 	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/julienlevasseur/profiler/pkg/remote"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var listTags []string
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "list profiles",
 	Run: func(cmd *cobra.Command, args []string) {
-		files := profile.ListFiles(
-			viper.GetString("profilesFolder"),
-			".*.yml",
-		)
+		if remoteBackendConfigured() {
+			fmt.Println("[Local Profiles]")
+		}
 
-		yamlFiles := profile.ListFiles(
-			viper.GetString("profilesFolder"),
-			"*.yaml",
-		)
+		listLocalProfiles(localProfileFiles())
+		listRemoteProfiles()
 
-		if viper.GetString("consulAddress") != "" || viper.GetString("ssmRegion") != "" {
-			fmt.Println("[Local Profiles]")
+		// The locally stored profiles come first:
+		for _, r := range backend.All() {
+			if r.Local && r.Configured() {
+				listBackendProfiles(r)
+			}
 		}
+		for _, r := range backend.All() {
+			if !r.Local && r.Configured() {
+				listBackendProfiles(r)
+			}
+		}
+	},
+}
 
-		files = append(files, yamlFiles...)
-		listLocalProfiles(files)
+// listBackendProfiles list the profiles of the given backend in their own
+// section
+func listBackendProfiles(r backend.Registration) {
+	profiles, err := r.ListProfiles()
+	if err != nil {
+		log.Printf("Error while listing %s profiles: %s", r.Title, err)
+	}
+
+	if r.Local {
+		fmt.Printf("\n[%s Profiles]\n", r.Title)
+	} else {
+		fmt.Printf("\n[%s Remote Profiles]\n", r.Title)
+	}
 
-		// Checking for Consul config:
-		if viper.GetString("consulAddress") != "" {
-			consulProfiles, err := consul.ListProfiles()
+	for _, p := range profiles {
+		if len(listTags) > 0 && r.GetMeta != nil {
+			m, err := r.GetMeta(p)
 			if err != nil {
-				log.Printf("Error while listing Consul profiles: %s", err)
+				log.Printf("Error while reading %s profile metadata: %s", r.Title, err)
+				continue
 			}
-			fmt.Println("\n[Consul Remote Profiles]")
-			for _, profile := range consulProfiles {
-				fmt.Println(profile)
+			if !m.HasTags(listTags) {
+				continue
 			}
 		}
-	},
+		fmt.Println(p)
+	}
 }
 
 func init() {
+	listCmd.Flags().StringSliceVar(
+		&listTags,
+		"tag",
+		[]string{},
+		"only list the profiles having the given tag (can be repeated)",
+	)
 	RootCmd.AddCommand(listCmd)
 }
 
+// remoteBackendConfigured tell if at least one backend is configured, the
+// local profiles then get their own section
+func remoteBackendConfigured() bool {
+	for _, r := range backend.All() {
+		if r.Configured() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localProfileFiles return the profile files found in the profiles folder
+func localProfileFiles() []string {
+	files := profile.ListFiles(
+		viper.GetString("profilesFolder"),
+		".*.yml",
+	)
+
+	yamlFiles := profile.ListFiles(
+		viper.GetString("profilesFolder"),
+		"*.yaml",
+	)
+
+	return append(files, yamlFiles...)
+}
+
+// localProfileName return the profile name of the given profile file
+func localProfileName(file string) string {
+	return strings.Split(
+		strings.Split(
+			file,
+			fmt.Sprintf(
+				"%s/.",
+				viper.GetString("profilesFolder"),
+			),
+		)[1], ".y", // The separator here is '.y' to support both .yml and .yaml files
+	)[0]
+}
+
 func listLocalProfiles(files []string) {
 	for _, file := range files {
-		fmt.Println(
-			strings.Split(
-				strings.Split(
-					file,
-					fmt.Sprintf(
-						"%s/.",
-						viper.GetString("profilesFolder"),
-					),
-				)[1], ".y", // The separator here is '.y' to support both .yml and .yaml files
-			)[0],
-		)
+		if len(listTags) > 0 && !profile.ParseMeta(file).HasTags(listTags) {
+			continue
+		}
+
+		fmt.Println(localProfileName(file))
+	}
+}
+
+// listRemoteProfiles list the profiles of the git remotes, as
+// `<remote>/<profile>`
+func listRemoteProfiles() {
+	profiles, err := remote.ListProfiles()
+	if err != nil {
+		log.Printf("Error while listing the git remotes profiles: %s", err)
+	}
+
+	for _, p := range profiles {
+		if len(listTags) > 0 {
+			file, err := remote.ProfileFile(p)
+			if err != nil || !profile.ParseMeta(file).HasTags(listTags) {
+				continue
+			}
+		}
+
+		fmt.Println(p)
 	}
 }
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/spf13/cobra"
+)
+
+// metaFlags hold the profile metadata provided to the remote `add` commands
+type metaFlags struct {
+	description    string
+	owner          string
+	tags           []string
+	keyDescription string
+}
+
+func (f *metaFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.description, "description", "", "description of the profile")
+	cmd.Flags().StringVar(&f.owner, "owner", "", "owner of the profile")
+	cmd.Flags().StringSliceVar(&f.tags, "tag", []string{}, "tag to add to the profile (can be repeated)")
+	cmd.Flags().StringVar(&f.keyDescription, "key-description", "", "description of the added variable")
+}
+
+// isSet return true if any profile level metadata has been provided
+func (f *metaFlags) isSet() bool {
+	return f.description != "" || f.owner != "" || len(f.tags) > 0
+}
+
+// apply merge the provided metadata into m
+func (f *metaFlags) apply(m *meta.Meta, key string) {
+	if f.description != "" {
+		m.Description = f.description
+	}
+	if f.owner != "" {
+		m.Owner = f.owner
+	}
+	m.AddTags(f.tags)
+	if key != "" && f.keyDescription != "" {
+		m.SetKeyDescription(key, f.keyDescription)
+	}
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/julienlevasseur/profiler/pkg/picker"
+	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/julienlevasseur/profiler/pkg/remote"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const localBackend = "local"
+
+var pickTags []string
+
+var pickCmd = &cobra.Command{
+	Use:   "pick",
+	Short: "interactively pick the profile to use among every configured backend",
+	Run: func(cmd *cobra.Command, args []string) {
+		pickProfile()
+	},
+}
+
+// pickProfile open the profile picker when running in a terminal, otherwise
+// it falls back to the local env files like `profiler use` without profile.
+func pickProfile() {
+	if !picker.IsInteractive() {
+		profile.UseNoProfile()
+		return
+	}
+
+	item, err := picker.Pick(collectProfiles(), previewProfile)
+	if errors.Is(err, picker.ErrAborted) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if r, ok := backend.Get(item.Backend); ok {
+		profile.UseBackend(r, item.Name)
+		return
+	}
+
+	profile.Use(viper.GetString("profilesFolder"), item.Name)
+}
+
+// collectProfiles list the profiles of every configured backend. A backend
+// that can't be reached is reported but doesn't prevent picking a profile
+// from the others.
+func collectProfiles() []picker.Item {
+	var items []picker.Item
+
+	for _, file := range localProfileFiles() {
+		m := profile.ParseMeta(file)
+		if len(pickTags) > 0 && !m.HasTags(pickTags) {
+			continue
+		}
+
+		items = append(items, picker.Item{
+			Name:        localProfileName(file),
+			Backend:     localBackend,
+			Description: m.Description,
+		})
+	}
+
+	remoteProfiles, err := remote.ListProfiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while listing the git remotes profiles: %s\n", err)
+	}
+	for _, p := range remoteProfiles {
+		if len(pickTags) > 0 {
+			file, err := remote.ProfileFile(p)
+			if err != nil || !profile.ParseMeta(file).HasTags(pickTags) {
+				continue
+			}
+		}
+
+		items = append(items, picker.Item{Name: p, Backend: localBackend})
+	}
+
+	for _, r := range backend.All() {
+		if !r.Configured() {
+			continue
+		}
+
+		// The backends without metadata have no profile matching tags:
+		if len(pickTags) > 0 && r.GetMeta == nil {
+			continue
+		}
+
+		profiles, err := r.ListProfiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while listing %s profiles: %s\n", r.Title, err)
+		}
+		items = append(items, backendItems(r.Name, profiles, r.GetMeta)...)
+	}
+
+	return items
+}
+
+// backendItems return the items of the given remote profiles with their
+// description, keeping only the profiles having the tags when given. A
+// profile whose metadata can't be read is still listed without description,
+// unless it has to be filtered by tag.
+func backendItems(backend string, profiles []string, getMeta func(string) (meta.Meta, error)) []picker.Item {
+	var items []picker.Item
+	for _, p := range profiles {
+		item := picker.Item{Name: p, Backend: backend}
+
+		if getMeta != nil {
+			m, err := getMeta(p)
+			if err != nil && len(pickTags) > 0 {
+				fmt.Fprintf(os.Stderr, "Error while reading the %s profile metadata: %s\n", p, err)
+				continue
+			}
+			if !m.HasTags(pickTags) {
+				continue
+			}
+			item.Description = m.Description
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// previewProfile return the variables name of the given profile
+func previewProfile(item picker.Item) []string {
+	var keys []string
+	var err error
+
+	if r, ok := backend.Get(item.Backend); ok {
+		keys, err = r.ShowProfile(item.Name)
+	} else if remote.IsRemoteProfile(item.Name) {
+		var file string
+		file, err = remote.ProfileFile(item.Name)
+		if err == nil {
+			keys = profileKeys(file)
+		}
+	} else {
+		// The profile may be a .yml or a .yaml file:
+		for _, ext := range []string{".yml", ".yaml"} {
+			file := viper.GetString("profilesFolder") + "/." + item.Name + ext
+			if profile.FileExist(file) {
+				keys = profileKeys(file)
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// profileKeys return the variables name of the given profile file
+func profileKeys(file string) []string {
+	var keys []string
+	for k := range profile.ParseYaml(file) {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func init() {
+	pickCmd.Flags().StringSliceVar(
+		&pickTags,
+		"tag",
+		[]string{},
+		"only pick among the profiles having the given tag (can be repeated)",
+	)
+	RootCmd.AddCommand(pickCmd)
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/julienlevasseur/profiler/pkg/remote"
+	"github.com/spf13/cobra"
+)
+
+var remoteCmd = &cobra.Command{
+	Use:   "remote",
+	Short: "deal with the git repositories of shared profiles",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || args[0] == "help" {
+			cmd.Help()
+			os.Exit(0)
+		}
+	},
+}
+
+var remoteAddCmd = &cobra.Command{
+	Use:   "add [remote_name] [url]",
+	Short: "clone the given git repository of profiles, listed as remote_name/<profile>",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := remote.Add(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var remoteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the git remotes",
+	Run: func(cmd *cobra.Command, args []string) {
+		remotes, err := remote.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		for _, r := range remotes {
+			fmt.Printf("%s\t%s\n", r.Name, r.URL)
+		}
+	},
+}
+
+var remoteRemoveCmd = &cobra.Command{
+	Use:   "remove [remote_name]",
+	Short: "remove the given git remote and its local clone",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := remote.Remove(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var remoteUpdateCmd = &cobra.Command{
+	Use:   "update [remote_name]",
+	Short: "pull the latest profiles of the given git remote (every remote by default)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+
+		err := remote.Update(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// printRemoteWrite run the write of a remote profile and tell where the change
+// has been pushed
+func printRemoteWrite(profileName string, write func() (string, error)) {
+	branch, err := write()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Change of %s committed and pushed to the %s branch, open a pull request to merge it\n", profileName, branch)
+}
+
+func init() {
+	remoteCmd.AddCommand(remoteAddCmd)
+	remoteCmd.AddCommand(remoteListCmd)
+	remoteCmd.AddCommand(remoteRemoveCmd)
+	remoteCmd.AddCommand(remoteUpdateCmd)
+	RootCmd.AddCommand(remoteCmd)
+}
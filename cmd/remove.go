@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/julienlevasseur/profiler/pkg/remote"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -22,6 +23,13 @@ var removeCmd = &cobra.Command{
 		} else if args[0] == "help" || args[0] == "" {
 			cmd.Help()
 			os.Exit(0)
+		} else if remote.IsRemoteProfile(args[0]) {
+			printRemoteWrite(args[0], func() (string, error) {
+				if len(args) < 2 {
+					return remote.DeleteProfile(args[0])
+				}
+				return remote.RemoveVariable(args[0], args[1])
+			})
 		} else {
 			// check if a variable has been provided or just a profile name:
 			if len(args) < 2 {
@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
 
@@ -44,6 +46,23 @@ func createProfilesFolder() error {
 	return nil
 }
 
+// confirm ask the given yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
+
 func writeDefaultConfigFile(homeFolder, configFile string) {
 	defaultConfig := []byte(
 		fmt.Sprintf("profilesFolder: %s/.profiles", homeFolder),
@@ -57,6 +76,13 @@ func writeDefaultConfigFile(homeFolder, configFile string) {
 
 func init() {
 	cobra.OnInitialize(InitConfig)
+
+	RootCmd.PersistentFlags().Bool(
+		"offline",
+		false,
+		"use the remote profiles from the offline cache, without contacting their backend",
+	)
+	viper.BindPFlag("offline", RootCmd.PersistentFlags().Lookup("offline"))
 }
 
 // InitConfig manage configuration
@@ -92,7 +118,12 @@ func InitConfig() {
 		viper.SetDefault("consulTokenFile", "")
 	}
 
+	viper.SetDefault("ssmPageSize", 10)
+	viper.SetDefault("ssmConcurrency", 5)
+	viper.SetDefault("ssmMaxRetries", 5)
 	viper.SetDefault("k8sSwitchNamespace", true)
+	viper.SetDefault("completionCacheTTL", "30s")
+	viper.SetDefault("offlineCacheTTL", "168h")
 
 	viper.AutomaticEnv()
 	viper.SetConfigType("yaml")
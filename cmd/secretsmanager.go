@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/secretsmanager"
+	"github.com/spf13/cobra"
+)
+
+var secretsManagerCmd = newBackendCmd(backend.SecretsManager, "in AWS Secrets Manager")
+
+func init() {
+	secretsManagerCmd.Aliases = []string{"sm"}
+	secretsManagerCmd.remove.Flags().BoolVar(
+		&backend.SecretsManagerForce,
+		"force",
+		false,
+		"delete the whole profile immediately, without recovery window",
+	)
+	for _, c := range []*cobra.Command{secretsManagerCmd.show, secretsManagerCmd.use} {
+		c.Flags().StringVar(
+			&backend.SecretsManagerStage,
+			"stage",
+			secretsmanager.StageCurrent,
+			"version stage of the profile ("+secretsmanager.StageCurrent+" or "+secretsmanager.StagePrevious+")",
+		)
+	}
+	RootCmd.AddCommand(secretsManagerCmd.Command)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/julienlevasseur/profiler/pkg/rest"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const defaultServeListen = "127.0.0.1:8087"
+
+var serveListen string
+var serveTLSCert string
+var serveTLSKey string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve the local profiles to the other machines over an authenticated REST API",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var tokens []rest.Token
+		err := viper.UnmarshalKey("serveTokens", &tokens)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(tokens) == 0 {
+			fmt.Fprintln(os.Stderr, "No token configured (serveTokens), nobody could access the profiles")
+			os.Exit(1)
+		}
+
+		listen := serveSetting(serveListen, "serveListen", defaultServeListen)
+		tlsCert := serveSetting(serveTLSCert, "serveTLSCert", "")
+		tlsKey := serveSetting(serveTLSKey, "serveTLSKey", "")
+
+		if (tlsCert == "") != (tlsKey == "") {
+			fmt.Fprintln(os.Stderr, "Both a TLS certificate and a TLS key are required")
+			os.Exit(1)
+		}
+		if tlsCert == "" && !loopback(listen) {
+			log.Printf("Warning: serving on %s without TLS, the tokens and the profiles are sent in clear", listen)
+		}
+
+		server := &http.Server{
+			Addr: listen,
+			Handler: &rest.Server{
+				ProfilesFolder: viper.GetString("profilesFolder"),
+				Tokens:         tokens,
+			},
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdown)
+		}()
+
+		log.Printf("Serving %s on %s", viper.GetString("profilesFolder"), listen)
+		if tlsCert != "" {
+			err = server.ListenAndServeTLS(tlsCert, tlsKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// serveSetting return the flag value when set, otherwise the configuration value
+// or the given default
+func serveSetting(flag string, key string, defaultValue string) string {
+	if flag != "" {
+		return flag
+	}
+	if viper.GetString(key) != "" {
+		return viper.GetString(key)
+	}
+
+	return defaultValue
+}
+
+// loopback tell if the given listen address only accepts local connections
+func loopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func init() {
+	serveCmd.Flags().StringVar(
+		&serveListen,
+		"listen",
+		"",
+		"address to listen on (serveListen, "+defaultServeListen+" by default)",
+	)
+	serveCmd.Flags().StringVar(
+		&serveTLSCert,
+		"tls-cert",
+		"",
+		"TLS certificate file (serveTLSCert)",
+	)
+	serveCmd.Flags().StringVar(
+		&serveTLSKey,
+		"tls-key",
+		"",
+		"TLS key file (serveTLSKey)",
+	)
+	RootCmd.AddCommand(serveCmd)
+}
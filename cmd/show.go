@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/julienlevasseur/profiler/pkg/meta"
 	"github.com/julienlevasseur/profiler/pkg/profile"
 
 	"github.com/spf13/cobra"
@@ -25,18 +27,49 @@ var showCmd = &cobra.Command{
 					p,
 				)
 
-				// Display Profile's name:
-				fmt.Printf("%s:\n", p)
-				// Display each Profile's env var name:
-				for _, v := range vars {
-					fmt.Printf("- %s\n", v)
-				}
-				fmt.Printf("\n")
+				printProfile(
+					p,
+					vars,
+					profile.GetMeta(viper.GetString("profilesFolder"), p),
+					nil,
+				)
 			}
 		}
 	},
 }
 
+// printProfile display the profile name, its metadata and its variables name,
+// flagging the encrypted ones
+func printProfile(profileName string, vars []string, m meta.Meta, encrypted []string) {
+	// Display Profile's name:
+	fmt.Printf("%s:\n", profileName)
+	// Display Profile's metadata:
+	if m.Description != "" {
+		fmt.Printf("  description: %s\n", m.Description)
+	}
+	if m.Owner != "" {
+		fmt.Printf("  owner: %s\n", m.Owner)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Printf("  tags: %s\n", strings.Join(m.Tags, ", "))
+	}
+	// Display each Profile's env var name:
+	for _, v := range vars {
+		line := fmt.Sprintf("- %s", v)
+		for _, e := range encrypted {
+			if e == v {
+				line += " (encrypted)"
+				break
+			}
+		}
+		if description, ok := m.Keys[v]; ok {
+			line += fmt.Sprintf(" # %s", description)
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\n")
+}
+
 func init() {
 	RootCmd.AddCommand(showCmd)
 }
@@ -4,7 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/julienlevasseur/profiler/pkg/profile"
 	"github.com/julienlevasseur/profiler/pkg/ssm"
 	"github.com/spf13/cobra"
 )
@@ -20,9 +26,14 @@ var ssmCmd = &cobra.Command{
 	},
 }
 
+var ssmAddMeta metaFlags
+var ssmAddSecure bool
+var ssmAddFromFile string
+var ssmListTags []string
+
 var ssmAddCmd = &cobra.Command{
 	Use:   "add [profile_name] [ENV_VAR=value]",
-	Short: "add the given profile or the given env var to the SSM profile",
+	Short: "add the given profile or the given env var (or every var of --from-file) to the SSM profile",
 	Run: func(cmd *cobra.Command, args []string) {
 		// Check first if the given profile exists
 		profileExist, err := ssm.ProfileExist(args[0])
@@ -32,7 +43,7 @@ var ssmAddCmd = &cobra.Command{
 		}
 
 		if !profileExist {
-			err = ssm.AddParameter(args[0]+"/profile_name", args[0])
+			err = ssm.AddParameter(args[0], "profile_name", args[0], "", false)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
@@ -46,7 +57,34 @@ var ssmAddCmd = &cobra.Command{
 				os.Exit(1)
 			}
 
-			err = ssm.AddParameter(args[0]+"/"+args[1], args[2])
+			err = ssm.AddParameter(
+				args[0],
+				args[1],
+				args[2],
+				ssmAddMeta.keyDescription,
+				ssmAddSecure,
+			)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		var m meta.Meta
+		if ssmAddFromFile != "" {
+			vars := profile.ParseYaml(ssmAddFromFile)
+			m = profile.ParseMeta(ssmAddFromFile)
+			// The profile_name parameter is managed by Profiler:
+			delete(vars, "profile_name")
+
+			err = ssm.AddParameters(args[0], vars, m.Keys, ssmAddSecure)
+			printBulkResult(len(vars), err)
+		}
+
+		if ssmAddMeta.isSet() || !m.IsEmpty() {
+			ssmAddMeta.apply(&m, "")
+
+			err = ssm.SetMeta(args[0], m)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
@@ -67,6 +105,16 @@ var ssmListCmd = &cobra.Command{
 		}
 
 		for _, p := range profiles {
+			if len(ssmListTags) > 0 {
+				m, err := ssm.GetMeta(p)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				if !m.HasTags(ssmListTags) {
+					continue
+				}
+			}
 			fmt.Println(p)
 		}
 	},
@@ -77,7 +125,7 @@ var ssmRemoveCmd = &cobra.Command{
 	Short: "remove the given profile or the given env var from the remote profile stored in AWS SSM",
 	Run: func(cmd *cobra.Command, args []string) {
 		// Check first if the given profile exists
-		profileExist, err := ssm.ProfileExist(args[1])
+		profileExist, err := ssm.ProfileExist(args[0])
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -89,27 +137,15 @@ var ssmRemoveCmd = &cobra.Command{
 			os.Exit(1)
 		} else {
 			// check if a variable has been provided or just a profile name:
-			if len(args) < 3 {
+			if len(args) < 2 {
 				// Only the profile name provided, delete all related params:
-				params, err := ssm.ShowProfile(args[1])
+				err := ssm.RemoveProfile(args[0])
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
 				}
-
-				for _, param := range params {
-					err := ssm.RemoveParameter(
-						"/profiler/" + args[1] + "/" + param,
-					)
-					if err != nil {
-						fmt.Fprintln(os.Stderr, err)
-						os.Exit(1)
-					}
-				}
 			} else {
-				err := ssm.RemoveParameter(
-					"/profiler/" + args[1] + "/" + args[2],
-				)
+				err := ssm.RemoveParameter(args[0], args[1])
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
@@ -130,21 +166,175 @@ var ssmShowCmd = &cobra.Command{
 				os.Exit(1)
 			}
 
-			// Display Profile's name:
-			fmt.Printf("%s:\n", p)
-			// Display each Profile's env var name:
-			for _, v := range vars {
-				fmt.Printf("- %s\n", v)
+			m, err := ssm.GetMeta(p)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			encrypted, err := ssm.SecureKeys(p)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			printProfile(p, vars, m, encrypted)
+		}
+	},
+}
+
+var ssmRollbackTo string
+var ssmRollbackYes bool
+
+var ssmHistoryCmd = &cobra.Command{
+	Use:   "history [profile_name] [ENV_VAR]",
+	Short: "show the versions of the given SSM profile variables",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var key string
+		if len(args) > 1 {
+			key = args[1]
+		}
+
+		history, err := ssm.History(args[0], key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		var keys []string
+		for k := range history {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Printf("%s:\n", k)
+			for _, v := range history[k] {
+				fmt.Printf(
+					"  v%d  %s  %s\n",
+					v.Version,
+					v.LastModifiedDate.Format(time.RFC3339),
+					v.LastModifiedUser,
+				)
 			}
-			fmt.Printf("\n")
 		}
 	},
 }
 
+var ssmRollbackCmd = &cobra.Command{
+	Use:   "rollback [profile_name] --to [timestamp|version]",
+	Short: "restore every variable of the SSM profile to its value at the given date or version",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		target, err := parseRollbackTarget(ssmRollbackTo)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		changes, err := ssm.PlanRollback(args[0], target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("Nothing to roll back")
+			return
+		}
+
+		// Display the plan:
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+
+		if !ssmRollbackYes && !confirm("Apply these changes?") {
+			os.Exit(1)
+		}
+
+		err = ssm.ApplyRollback(args[0], changes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// parseRollbackTarget parse the `--to` flag, either a version number or a date
+func parseRollbackTarget(to string) (ssm.RollbackTarget, error) {
+	if to == "" {
+		return ssm.RollbackTarget{}, errors.New("Please provide the date or version to roll back to with --to")
+	}
+
+	if version, err := strconv.ParseInt(to, 10, 64); err == nil {
+		return ssm.RollbackTarget{Version: version}, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, to, time.Local); err == nil {
+			return ssm.RollbackTarget{Time: t}, nil
+		}
+	}
+
+	return ssm.RollbackTarget{}, fmt.Errorf("Invalid rollback target %s (expecting a version number or a RFC3339 date)", to)
+}
+
+// printBulkResult display the summary of a bulk write and exit on failure
+func printBulkResult(total int, err error) {
+	var bulkErr *ssm.BulkError
+	if errors.As(err, &bulkErr) {
+		fmt.Printf("%d of %d variables written\n", total-len(bulkErr.Failures), total)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d variables written\n", total)
+}
+
 func init() {
+	ssmAddMeta.register(ssmAddCmd)
+	ssmAddCmd.Flags().StringVar(
+		&ssmAddFromFile,
+		"from-file",
+		"",
+		"add every variable of the given YAML profile file",
+	)
+	ssmAddCmd.Flags().BoolVar(
+		&ssmAddSecure,
+		"secure",
+		false,
+		"store the variable as an encrypted SecureString (automatic for secret looking names)",
+	)
+	ssmListCmd.Flags().StringSliceVar(
+		&ssmListTags,
+		"tag",
+		[]string{},
+		"only list the profiles having the given tag (can be repeated)",
+	)
+	ssmRollbackCmd.Flags().StringVar(
+		&ssmRollbackTo,
+		"to",
+		"",
+		"date (RFC3339) or version to roll back to",
+	)
+	ssmRollbackCmd.Flags().BoolVarP(
+		&ssmRollbackYes,
+		"yes",
+		"y",
+		false,
+		"apply the rollback without confirmation",
+	)
 	ssmCmd.AddCommand(ssmAddCmd)
+	ssmCmd.AddCommand(ssmHistoryCmd)
+	ssmCmd.AddCommand(ssmRollbackCmd)
 	ssmCmd.AddCommand(ssmListCmd)
 	ssmCmd.AddCommand(ssmRemoveCmd)
 	ssmCmd.AddCommand(ssmShowCmd)
+	ssmCmd.AddCommand(newUseCmd(registration(backend.SSM)))
 	RootCmd.AddCommand(ssmCmd)
 }
@@ -10,10 +10,10 @@ import (
 
 var useCmd = &cobra.Command{
 	Use:   "use [profile_name]",
-	Short: "use the given profile",
+	Short: "use the given profile (pick it interactively if none is given)",
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
-			profile.UseNoProfile()
+			pickProfile()
 		} else if args[0] == "help" {
 			cmd.Help()
 			os.Exit(0)
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/vault"
+	"github.com/spf13/cobra"
+)
+
+var vaultCmd = newBackendCmd(backend.Vault, "in HashiCorp Vault (KV v2)")
+
+var vaultRollbackTo int
+var vaultRollbackYes bool
+
+var vaultHistoryCmd = &cobra.Command{
+	Use:   "history [profile_name]",
+	Short: "show the versions of the given Vault profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		versions, err := vault.History(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		for _, v := range versions {
+			var state string
+			if v.Destroyed {
+				state = "  (destroyed)"
+			} else if v.Deleted {
+				state = "  (deleted)"
+			}
+			fmt.Printf("v%d  %s%s\n", v.Version, v.CreatedTime.Format(time.RFC3339), state)
+		}
+	},
+}
+
+var vaultRollbackCmd = &cobra.Command{
+	Use:   "rollback [profile_name] --to [version]",
+	Short: "write the given version of the Vault profile as its new version",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if vaultRollbackTo < 1 {
+			fmt.Fprintln(os.Stderr, errors.New("Please provide the version to roll back to with --to"))
+			os.Exit(1)
+		}
+
+		if !vaultRollbackYes && !confirm(fmt.Sprintf("Restore the version %d of the %s profile?", vaultRollbackTo, args[0])) {
+			os.Exit(1)
+		}
+
+		err := vault.Rollback(args[0], vaultRollbackTo)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{vaultCmd.show, vaultCmd.use} {
+		c.Flags().IntVar(
+			&backend.VaultVersion,
+			"version",
+			0,
+			"version of the profile (the current one by default)",
+		)
+	}
+	vaultRollbackCmd.Flags().IntVar(
+		&vaultRollbackTo,
+		"to",
+		0,
+		"version to roll back to",
+	)
+	vaultRollbackCmd.Flags().BoolVarP(
+		&vaultRollbackYes,
+		"yes",
+		"y",
+		false,
+		"apply the rollback without confirmation",
+	)
+	vaultCmd.AddCommand(vaultHistoryCmd)
+	vaultCmd.AddCommand(vaultRollbackCmd)
+	RootCmd.AddCommand(vaultCmd.Command)
+}
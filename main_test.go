@@ -1,17 +1,66 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"os/exec"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	awssecretsmanager "github.com/aws/aws-sdk-go/service/secretsmanager"
+	awsssm "github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/hashicorp/consul/api"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/spf13/viper"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 
 	"github.com/julienlevasseur/profiler/cmd"
+	"github.com/julienlevasseur/profiler/pkg/agent"
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/cache"
+	"github.com/julienlevasseur/profiler/pkg/completion"
+	"github.com/julienlevasseur/profiler/pkg/consul"
+	"github.com/julienlevasseur/profiler/pkg/consul/consulfake"
+	"github.com/julienlevasseur/profiler/pkg/etcd"
+	"github.com/julienlevasseur/profiler/pkg/k8s"
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/julienlevasseur/profiler/pkg/pass"
+	"github.com/julienlevasseur/profiler/pkg/picker"
 	"github.com/julienlevasseur/profiler/pkg/profile"
+	"github.com/julienlevasseur/profiler/pkg/remote"
+	"github.com/julienlevasseur/profiler/pkg/rest"
+	"github.com/julienlevasseur/profiler/pkg/s3"
+	"github.com/julienlevasseur/profiler/pkg/s3/s3fake"
+	"github.com/julienlevasseur/profiler/pkg/secretsmanager"
+	"github.com/julienlevasseur/profiler/pkg/secretsmanager/secretsmanagerfake"
+	"github.com/julienlevasseur/profiler/pkg/ssm"
+	"github.com/julienlevasseur/profiler/pkg/ssm/ssmfake"
+	"github.com/julienlevasseur/profiler/pkg/vault"
+	"github.com/julienlevasseur/profiler/pkg/vault/vaultfake"
 )
 
 var configFile string = "/tmp/.profiler_cfg.yml"
@@ -46,6 +95,147 @@ func copyFile(source string, destination string) {
 	}
 }
 
+// concurrentKV simulates a teammate updating the profile between the read and
+// the transaction of the first writes
+type concurrentKV struct {
+	*consulfake.KV
+	conflicts int
+}
+
+func (kv *concurrentKV) Txn(ops api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	if kv.conflicts > 0 {
+		kv.conflicts--
+		var value []byte
+		if current, _, _ := kv.Get(ops[0].Key, nil); current != nil {
+			value = current.Value
+		}
+		kv.Put(&api.KVPair{
+			Key:   ops[0].Key,
+			Value: append(value, []byte(fmt.Sprintf("TEAMMATE_%d: x\n", kv.conflicts))...),
+		}, nil)
+	}
+
+	return kv.KV.Txn(ops, q)
+}
+
+// unreachableKV simulates a Consul agent failing the next List calls
+type unreachableKV struct {
+	*consulfake.KV
+	failures int
+}
+
+func (kv *unreachableKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	if kv.failures > 0 {
+		kv.failures--
+		return nil, nil, errors.New("connection refused")
+	}
+
+	return kv.KV.List(prefix, q)
+}
+
+// concurrentS3 simulates a teammate updating the profile object between the
+// read and the conditional write of Profiler
+type concurrentS3 struct {
+	*s3fake.S3
+	conflicts int
+}
+
+func (c *concurrentS3) PutObjectWithContext(ctx aws.Context, input *awss3.PutObjectInput, opts ...request.Option) (*awss3.PutObjectOutput, error) {
+	if c.conflicts > 0 {
+		c.conflicts--
+		bucket, key := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+		var value []byte
+		if current := c.Get(bucket, key); current != nil {
+			value = current.Body
+		}
+		c.Put(bucket, key, append(value, []byte(fmt.Sprintf("TEAMMATE_%d: x\n", c.conflicts))...), nil)
+	}
+
+	return c.S3.PutObjectWithContext(ctx, input, opts...)
+}
+
+// concurrentSecretsManager simulates a teammate updating the profile secret
+// between the read and the write of Profiler
+type concurrentSecretsManager struct {
+	*secretsmanagerfake.SecretsManager
+	conflicts int
+}
+
+func (c *concurrentSecretsManager) GetSecretValue(input *awssecretsmanager.GetSecretValueInput) (*awssecretsmanager.GetSecretValueOutput, error) {
+	output, err := c.SecretsManager.GetSecretValue(input)
+	if err != nil || c.conflicts == 0 {
+		return output, err
+	}
+
+	c.conflicts--
+	vars := make(map[string]string)
+	Expect(json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &vars)).To(Succeed())
+	vars[fmt.Sprintf("TEAMMATE_%d", c.conflicts)] = "x"
+	value, err := json.Marshal(vars)
+	Expect(err).To(BeNil())
+	_, err = c.SecretsManager.PutSecretValue(&awssecretsmanager.PutSecretValueInput{
+		SecretId:     input.SecretId,
+		SecretString: aws.String(string(value)),
+	})
+
+	return output, err
+}
+
+// etcdServer is the embedded etcd server of the etcd backend tests, started
+// by the first of them
+var etcdServer *embed.Etcd
+
+func startEtcd() string {
+	if etcdServer == nil {
+		dir, err := ioutil.TempDir("", "profiler-etcd")
+		Expect(err).To(BeNil())
+
+		local, _ := url.Parse("http://127.0.0.1:0")
+		config := embed.NewConfig()
+		config.Dir = dir
+		config.LogLevel = "error"
+		config.LCUrls = []url.URL{*local}
+		config.LPUrls = []url.URL{*local}
+
+		etcdServer, err = embed.StartEtcd(config)
+		Expect(err).To(BeNil())
+		Eventually(etcdServer.Server.ReadyNotify(), 10*time.Second).Should(BeClosed())
+	}
+
+	return etcdServer.Clients[0].Addr().String()
+}
+
+// gnupgHome is the GnuPG home of the pass backend tests, holding a key
+// generated by the first of them
+var gnupgHome string
+
+const gpgTestID = "profiler-test@example.com"
+
+func setupGnuPG() {
+	if gnupgHome == "" {
+		dir, err := ioutil.TempDir("", "profiler-gnupg")
+		Expect(err).To(BeNil())
+		os.Setenv("GNUPGHOME", dir)
+
+		out, err := exec.Command(
+			"gpg", "--batch", "--quiet", "--passphrase", "",
+			"--quick-gen-key", gpgTestID, "default", "default", "never",
+		).CombinedOutput()
+		Expect(err).To(BeNil(), string(out))
+		gnupgHome = dir
+	}
+}
+
+// runGit run a git command of the test setup
+func runGit(dir string, args ...string) string {
+	command := exec.Command("git", args...)
+	command.Dir = dir
+	out, err := command.CombinedOutput()
+	Expect(err).To(BeNil(), string(out))
+
+	return strings.TrimSpace(string(out))
+}
+
 func Test(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Profiler")
@@ -196,6 +386,28 @@ var _ = Describe("Profiler", func() {
 
 	})
 
+	Context("ParseMeta", func() {
+
+		It("should not expose the metadata as a variable", func() {
+			vars := profile.ParseYaml("test/.meta.yml")
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+			Expect(vars).To(Not(HaveKey("_meta")))
+		})
+
+		It("should parse the metadata section", func() {
+			m := profile.ParseMeta("test/.meta.yml")
+			Expect(m.Description).To(Equal("Test profile"))
+			Expect(m.Owner).To(Equal("platform"))
+			Expect(m.Keys).To(HaveKeyWithValue("FOO", "The foo variable"))
+			Expect(m.HasTags([]string{"aws", "dev"})).To(BeTrue())
+			Expect(m.HasTags([]string{"aws", "prod"})).To(BeFalse())
+		})
+
+		It("should return empty metadata for profiles without it", func() {
+			Expect(profile.ParseMeta("test/.test.yml").IsEmpty()).To(BeTrue())
+		})
+	})
+
 	Context("ParseEnvrc", func() {
 
 		It("should be type of KeyValueMap", func() {
@@ -215,38 +427,2277 @@ var _ = Describe("Profiler", func() {
 
 	})
 
-	Context("Alternate config", func() {
-		// Simulate a user setings his custom configFile path:
-		os.Setenv("PROFILER_CFG", altConfigFile)
+	Context("Picker Filter", func() {
+		items := []picker.Item{
+			{Name: "a_web_service_devtools", Backend: "local"},
+			{Name: "aws_dev", Backend: "ssm"},
+			{Name: "gcp_prod", Backend: "consul"},
+		}
 
-		It("should be type of []string", func() {
-			Expect(reflect.TypeOf(altFiles).String()).To(Equal("[]string"))
+		It("should only keep the matching items", func() {
+			Expect(picker.Filter(items, "awsdev")).To(HaveLen(2))
+			Expect(picker.Filter(items, "prod")).To(Equal([]picker.Item{items[2]}))
 		})
 
-		It("should contain 3 elements", func() {
-			Expect(altFiles).To(HaveLen(3))
+		It("should rank the best match first", func() {
+			Expect(picker.Filter(items, "awsdev")[0].Name).To(Equal("aws_dev"))
 		})
 
-		It("should contain .env.yml, .testrc & .test.yml", func() {
-			Expect(altFiles).To(ContainElement(altProfilesPath + ".test.yml"))
+		It("should keep every item with an empty query", func() {
+			Expect(picker.Filter(items, "")).To(Equal(items))
+		})
+
+		It("should truncate the lines without splitting characters", func() {
+			Expect(picker.Truncate("> café_prod", 6)).To(Equal("> café"))
+			Expect(picker.Truncate("> 日本語", 3)).To(Equal("> 日"))
+			Expect(picker.Truncate("short", 10)).To(Equal("short"))
 		})
 	})
 
-	Context("setConfigFile", func() {
-		// Simulate a user setings his custom configFile path:
-		os.Setenv("PROFILER_CFG", noCfgFilePath)
+	Context("SSM backend", func() {
+		var fake *ssmfake.SSM
 
-		cmd.InitConfig()
+		putParameter := func(name, value string) {
+			_, err := fake.PutParameter(&awsssm.PutParameterInput{
+				Name:  aws.String(name),
+				Value: aws.String(value),
+				Type:  aws.String(awsssm.ParameterTypeString),
+			})
+			Expect(err).To(BeNil())
+		}
 
-		It("should have created a config file", func() {
-			Expect(noCfgFilePath).To(BeAnExistingFile())
+		BeforeEach(func() {
+			fake = ssmfake.New()
+			ssm.SetService(fake)
+		})
+
+		AfterEach(func() {
+			ssm.SetService(nil)
+		})
+
+		It("should return every variable of a profile spread over several pages", func() {
+			for i := 0; i < 25; i++ {
+				putParameter(fmt.Sprintf("/profiler/paged/KEY_%d", i), strconv.Itoa(i))
+			}
+
+			vars, err := ssm.GetProfile("paged")
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveLen(25))
+			Expect(vars).To(HaveKeyWithValue("KEY_24", "24"))
+			Expect(fake.Calls["GetParametersByPath"]).To(Equal(3))
+		})
+
+		It("should list the profiles found on every page", func() {
+			for i := 0; i < 12; i++ {
+				putParameter(fmt.Sprintf("/profiler/paged/KEY_%d", i), strconv.Itoa(i))
+			}
+			putParameter("/profiler/zz_other/profile_name", "zz_other")
+
+			profiles, err := ssm.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"paged", "zz_other"}))
+		})
+
+		It("should report the API errors", func() {
+			fake.FailNext("GetParametersByPath", awserr.New("AccessDeniedException", "denied", nil))
+
+			_, err := ssm.ListProfiles()
+			Expect(err).To(MatchError(ContainSubstring("AccessDeniedException")))
+		})
+
+		It("should add and remove variables", func() {
+			Expect(ssm.AddParameter("dev", "profile_name", "dev", "", false)).To(Succeed())
+			Expect(ssm.AddParameter("dev", "FOO", "bar", "The foo", false)).To(Succeed())
+			Expect(ssm.AddParameter("dev", "DB_PASSWORD", "secret", "", false)).To(Succeed())
+
+			exist, err := ssm.ProfileExist("dev")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeTrue())
+
+			secure, err := ssm.SecureKeys("dev")
+			Expect(err).To(BeNil())
+			Expect(secure).To(Equal([]string{"DB_PASSWORD"}))
+
+			m, err := ssm.GetMeta("dev")
+			Expect(err).To(BeNil())
+			Expect(m.Keys).To(HaveKeyWithValue("FOO", "The foo"))
+
+			Expect(ssm.RemoveParameter("dev", "FOO")).To(Succeed())
+			vars, err := ssm.ShowProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(ConsistOf("profile_name", "DB_PASSWORD"))
+		})
+
+		It("should fail to remove a missing variable", func() {
+			Expect(ssm.RemoveParameter("dev", "MISSING")).To(Not(Succeed()))
+		})
+
+		Context("SecureString", func() {
+			// stored return the latest version of the given parameter
+			stored := func(name string) *awsssm.ParameterHistory {
+				output, err := fake.GetParameterHistory(&awsssm.GetParameterHistoryInput{Name: aws.String(name)})
+				Expect(err).To(BeNil())
+				return output.Parameters[len(output.Parameters)-1]
+			}
+
+			AfterEach(func() {
+				viper.Set("ssmKmsKeyId", "")
+			})
+
+			It("should detect the secret looking variables", func() {
+				for _, key := range []string{"DB_PASSWORD", "aws_secret_access_key", "GITHUB_TOKEN", "PRIVATE_KEY", "STRIPE_API_KEY", "Passwd"} {
+					Expect(ssm.IsSecretKey(key)).To(BeTrue(), key)
+				}
+				for _, key := range []string{"AWS_REGION", "profile_name", "KEYBOARD", "HOST"} {
+					Expect(ssm.IsSecretKey(key)).To(BeFalse(), key)
+				}
+			})
+
+			It("should store the secret looking variables as SecureString", func() {
+				Expect(ssm.AddParameter("dev", "DB_PASSWORD", "s3cr3t", "", false)).To(Succeed())
+
+				p := stored("/profiler/dev/DB_PASSWORD")
+				Expect(aws.StringValue(p.Type)).To(Equal(awsssm.ParameterTypeSecureString))
+				Expect(p.KeyId).To(BeNil())
+			})
+
+			It("should encrypt with the ssmKmsKeyId key", func() {
+				viper.Set("ssmKmsKeyId", "alias/profiler")
+				Expect(ssm.AddParameter("dev", "DB_PASSWORD", "s3cr3t", "", false)).To(Succeed())
+				Expect(ssm.AddParameter("dev", "AWS_REGION", "us-east-1", "", false)).To(Succeed())
+
+				Expect(aws.StringValue(stored("/profiler/dev/DB_PASSWORD").KeyId)).To(Equal("alias/profiler"))
+				// The key is only used for the SecureString parameters:
+				Expect(stored("/profiler/dev/AWS_REGION").KeyId).To(BeNil())
+			})
+
+			It("should keep the other variables as String", func() {
+				Expect(ssm.AddParameter("dev", "AWS_REGION", "us-east-1", "", false)).To(Succeed())
+
+				Expect(aws.StringValue(stored("/profiler/dev/AWS_REGION").Type)).To(Equal(awsssm.ParameterTypeString))
+			})
+
+			It("should force SecureString with --secure", func() {
+				Expect(ssm.AddParameter("dev", "AWS_REGION", "us-east-1", "", true)).To(Succeed())
+				Expect(ssm.AddParameters("dev", map[string]string{"HOST": "db.example.com"}, nil, true)).To(Succeed())
+
+				Expect(aws.StringValue(stored("/profiler/dev/AWS_REGION").Type)).To(Equal(awsssm.ParameterTypeSecureString))
+				Expect(aws.StringValue(stored("/profiler/dev/HOST").Type)).To(Equal(awsssm.ParameterTypeSecureString))
+
+				secure, err := ssm.SecureKeys("dev")
+				Expect(err).To(BeNil())
+				Expect(secure).To(ConsistOf("AWS_REGION", "HOST"))
+			})
+
+			It("should tell the profiles holding SecureString parameters are secret", func() {
+				r, ok := backend.Get(backend.SSM)
+				Expect(ok).To(BeTrue())
+
+				Expect(ssm.AddParameter("dev", "AWS_REGION", "us-east-1", "", false)).To(Succeed())
+				Expect(r.IsSecret("dev")).To(BeFalse())
+
+				Expect(ssm.AddParameter("dev", "DB_PASSWORD", "s3cr3t", "", false)).To(Succeed())
+				Expect(r.IsSecret("dev")).To(BeTrue())
+			})
+		})
+
+		Context("with mounts", func() {
+			BeforeEach(func() {
+				viper.Set("ssmMounts", map[string]string{"platform": "/teams/platform/profiler"})
+				putParameter("/profiler/local_dev/FOO", "foo")
+				putParameter("/teams/platform/profiler/aws_dev/AWS_REGION", "us-east-1")
+				putParameter("/teams/platform/profiler/aws_dev/nested/BAR", "bar")
+			})
+
+			AfterEach(func() {
+				viper.Set("ssmMounts", map[string]string{})
+			})
+
+			It("should list the profiles of every mount", func() {
+				profiles, err := ssm.ListProfiles()
+				Expect(err).To(BeNil())
+				Expect(profiles).To(Equal([]string{"local_dev", "platform/aws_dev"}))
+			})
+
+			It("should resolve the mount from the profile name", func() {
+				vars, err := ssm.GetProfile("platform/aws_dev")
+				Expect(err).To(BeNil())
+				Expect(vars).To(HaveKeyWithValue("AWS_REGION", "us-east-1"))
+				Expect(vars).To(HaveKeyWithValue("nested/BAR", "bar"))
+			})
+		})
+
+		It("should address the nested parameters by their path in the profile", func() {
+			putParameter("/profiler/dev/FOO", "foo")
+			putParameter("/profiler/dev/db/HOST", "db.example.com")
+			putParameter("/profiler/dev/cache/HOST", "cache.example.com")
+
+			vars, err := ssm.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{
+				"FOO":        "foo",
+				"db/HOST":    "db.example.com",
+				"cache/HOST": "cache.example.com",
+			}))
+
+			history, err := ssm.History("dev", "db/HOST")
+			Expect(err).To(BeNil())
+			Expect(history["db/HOST"]).To(HaveLen(1))
+
+			Expect(ssm.RemoveParameter("dev", "db/HOST")).To(Succeed())
+			keys, err := ssm.ShowProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(keys).To(ConsistOf("FOO", "cache/HOST"))
+
+			profiles, err := ssm.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev"}))
+		})
+
+		Context("history and rollback", func() {
+			It("should list the versions of the variables", func() {
+				putParameter("/profiler/dev/FOO", "a")
+				putParameter("/profiler/dev/BAR", "bar")
+
+				history, err := ssm.History("dev", "")
+				Expect(err).To(BeNil())
+				Expect(history).To(HaveLen(2))
+				Expect(history["FOO"]).To(HaveLen(1))
+				Expect(history["FOO"][0].Value).To(Equal("a"))
+
+				history, err = ssm.History("dev", "BAR")
+				Expect(err).To(BeNil())
+				Expect(history).To(HaveKey("BAR"))
+				Expect(history).NotTo(HaveKey("FOO"))
+			})
+
+			It("should roll back to a version", func() {
+				for _, value := range []string{"a", "b", "c"} {
+					Expect(ssm.AddParameters("dev", map[string]string{"FOO": value}, nil, false)).To(Succeed())
+				}
+
+				changes, err := ssm.PlanRollback("dev", ssm.RollbackTarget{Version: 2})
+				Expect(err).To(BeNil())
+				Expect(changes).To(HaveLen(1))
+				Expect(changes[0].String()).To(Equal(`~ FOO v3 -> v2 ("c" -> "b")`))
+
+				Expect(ssm.ApplyRollback("dev", changes)).To(Succeed())
+				vars, err := ssm.GetProfile("dev")
+				Expect(err).To(BeNil())
+				Expect(vars).To(Equal(map[string]string{"FOO": "b"}))
+
+				// The rollback is a new version:
+				history, err := ssm.History("dev", "FOO")
+				Expect(err).To(BeNil())
+				Expect(history["FOO"]).To(HaveLen(4))
+			})
+
+			It("should keep the tier and description of the restored version", func() {
+				viper.Set("ssmParameterTier", awsssm.ParameterTierAdvanced)
+				defer viper.Set("ssmParameterTier", "")
+				Expect(ssm.AddParameters("dev", map[string]string{"FOO": "a"}, map[string]string{"FOO": "The foo"}, false)).To(Succeed())
+				Expect(ssm.AddParameters("dev", map[string]string{"FOO": "b"}, nil, false)).To(Succeed())
+
+				changes, err := ssm.PlanRollback("dev", ssm.RollbackTarget{Version: 1})
+				Expect(err).To(BeNil())
+				viper.Set("ssmParameterTier", "")
+				Expect(ssm.ApplyRollback("dev", changes)).To(Succeed())
+
+				history, err := ssm.History("dev", "FOO")
+				Expect(err).To(BeNil())
+				restored := history["FOO"][len(history["FOO"])-1]
+				Expect(restored.Value).To(Equal("a"))
+				Expect(restored.Description).To(Equal("The foo"))
+				Expect(restored.Tier).To(Equal(awsssm.ParameterTierAdvanced))
+			})
+
+			It("should roll back to a date, deleting the variables created since", func() {
+				Expect(ssm.AddParameters("dev", map[string]string{"FOO": "a", "BAR": "bar"}, nil, false)).To(Succeed())
+				time.Sleep(10 * time.Millisecond)
+				target := time.Now()
+				time.Sleep(10 * time.Millisecond)
+				Expect(ssm.AddParameters("dev", map[string]string{"FOO": "b", "NEW": "new"}, nil, false)).To(Succeed())
+
+				changes, err := ssm.PlanRollback("dev", ssm.RollbackTarget{Time: target})
+				Expect(err).To(BeNil())
+				var plan []string
+				for _, c := range changes {
+					plan = append(plan, c.String())
+				}
+				Expect(plan).To(Equal([]string{
+					`~ FOO v2 -> v1 ("b" -> "a")`,
+					"- NEW (v1, did not exist yet)",
+				}))
+
+				Expect(ssm.ApplyRollback("dev", changes)).To(Succeed())
+				vars, err := ssm.GetProfile("dev")
+				Expect(err).To(BeNil())
+				Expect(vars).To(Equal(map[string]string{"FOO": "a", "BAR": "bar"}))
+			})
+
+			It("should not display the encrypted values in the plan", func() {
+				viper.Set("ssmKmsKeyId", "alias/profiler")
+				defer viper.Set("ssmKmsKeyId", "")
+				Expect(ssm.AddParameters("dev", map[string]string{"DB_PASSWORD": "old-s3cr3t"}, nil, false)).To(Succeed())
+				Expect(ssm.AddParameters("dev", map[string]string{"DB_PASSWORD": "new-s3cr3t"}, nil, false)).To(Succeed())
+
+				changes, err := ssm.PlanRollback("dev", ssm.RollbackTarget{Version: 1})
+				Expect(err).To(BeNil())
+				Expect(changes).To(HaveLen(1))
+				Expect(changes[0].String()).To(Equal("~ DB_PASSWORD v2 -> v1 (encrypted)"))
+
+				// The restored version keeps its type and key:
+				Expect(ssm.ApplyRollback("dev", changes)).To(Succeed())
+				output, err := fake.GetParameterHistory(&awsssm.GetParameterHistoryInput{Name: aws.String("/profiler/dev/DB_PASSWORD")})
+				Expect(err).To(BeNil())
+				restored := output.Parameters[len(output.Parameters)-1]
+				Expect(aws.StringValue(restored.Value)).To(Equal("old-s3cr3t"))
+				Expect(aws.StringValue(restored.Type)).To(Equal(awsssm.ParameterTypeSecureString))
+				Expect(aws.StringValue(restored.KeyId)).To(Equal("alias/profiler"))
+			})
+		})
+
+		Context("bulk operations", func() {
+			BeforeEach(func() {
+				viper.Set("ssmConcurrency", 4)
+				viper.Set("ssmMaxRetries", 3)
+			})
+
+			It("should write every variable, retrying throttled calls", func() {
+				putParameter("/profiler/bulk/FOO", "old")
+				throttling := awserr.New("ThrottlingException", "Rate exceeded", nil)
+				fake.FailNext("PutParameter", throttling, throttling)
+
+				vars := map[string]string{"FOO": "foo", "BAR": "bar", "BAZ": "baz"}
+				Expect(ssm.AddParameters("bulk", vars, nil, false)).To(Succeed())
+
+				written, err := ssm.GetProfile("bulk")
+				Expect(err).To(BeNil())
+				Expect(written).To(Equal(vars))
+			})
+
+			It("should report the variables that could not be written", func() {
+				viper.Set("ssmMaxRetries", 0)
+				fake.FailNext("PutParameter", awserr.New("ThrottlingException", "Rate exceeded", nil))
+
+				err := ssm.AddParameters("bulk", map[string]string{"FOO": "foo", "BAR": "bar"}, nil, false)
+				var bulkErr *ssm.BulkError
+				Expect(errors.As(err, &bulkErr)).To(BeTrue())
+				Expect(bulkErr.Failures).To(HaveLen(1))
+			})
+
+			It("should delete the profile by batches of 10", func() {
+				for i := 0; i < 25; i++ {
+					putParameter(fmt.Sprintf("/profiler/bulk/KEY_%d", i), strconv.Itoa(i))
+				}
+
+				Expect(ssm.RemoveProfile("bulk")).To(Succeed())
+				Expect(fake.Calls["DeleteParameters"]).To(Equal(3))
+
+				profiles, err := ssm.ListProfiles()
+				Expect(err).To(BeNil())
+				Expect(profiles).To(BeEmpty())
+			})
 		})
 	})
 
-	AfterSuite(func() {
-		// Cleanup:
-		os.Remove(configFile)
-		os.Remove(noCfgFilePath)
-		os.RemoveAll(profilesPath)
+	Context("SSM session", func() {
+		var folder string
+		var sts *httptest.Server
+		var assumeRole url.Values
+
+		BeforeEach(func() {
+			var err error
+			folder, err = ioutil.TempDir("", "profiler-aws")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(folder+"/config", []byte("[profile ssm-test]\nregion = ap-southeast-2\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(folder+"/credentials", []byte("[ssm-test]\naws_access_key_id = AKIDSSMTEST\naws_secret_access_key = secret\n"), 0600)).To(Succeed())
+			os.Setenv("AWS_CONFIG_FILE", folder+"/config")
+			os.Setenv("AWS_SHARED_CREDENTIALS_FILE", folder+"/credentials")
+
+			// STS is reached through the `ssmEndpoint` too:
+			assumeRole = nil
+			sts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				assumeRole = r.PostForm
+				fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>`+
+					`<Credentials><AccessKeyId>ASIAROLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration></Credentials>`+
+					`<AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/profiler/ci</Arn><AssumedRoleId>AROA:ci</AssumedRoleId></AssumedRoleUser>`+
+					`</AssumeRoleResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></AssumeRoleResponse>`)
+			}))
+
+			viper.Set("ssmRegion", "eu-west-3")
+			viper.Set("ssmAwsProfile", "ssm-test")
+			viper.Set("ssmEndpoint", sts.URL)
+		})
+
+		AfterEach(func() {
+			sts.Close()
+			os.Unsetenv("AWS_CONFIG_FILE")
+			os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+			os.RemoveAll(folder)
+			viper.Set("ssmRegion", "us-east-1")
+			for _, key := range []string{"ssmAwsProfile", "ssmEndpoint", "ssmRoleArn", "ssmRoleSessionName", "ssmRoleExternalId"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should use the ssmAwsProfile credentials and the ssmEndpoint", func() {
+			sess, err := ssm.NewAWSSession()
+			Expect(err).To(BeNil())
+			Expect(aws.StringValue(sess.Config.Region)).To(Equal("eu-west-3"))
+			Expect(aws.StringValue(sess.Config.Endpoint)).To(Equal(sts.URL))
+
+			creds, err := sess.Config.Credentials.Get()
+			Expect(err).To(BeNil())
+			Expect(creds.AccessKeyID).To(Equal("AKIDSSMTEST"))
+			Expect(assumeRole).To(BeNil())
+		})
+
+		It("should assume the ssmRoleArn role", func() {
+			viper.Set("ssmRoleArn", "arn:aws:iam::123456789012:role/profiler")
+			viper.Set("ssmRoleSessionName", "ci")
+			viper.Set("ssmRoleExternalId", "ext-42")
+
+			sess, err := ssm.NewAWSSession()
+			Expect(err).To(BeNil())
+
+			creds, err := sess.Config.Credentials.Get()
+			Expect(err).To(BeNil())
+			Expect(creds.AccessKeyID).To(Equal("ASIAROLE"))
+			Expect(assumeRole.Get("Action")).To(Equal("AssumeRole"))
+			Expect(assumeRole.Get("RoleArn")).To(Equal("arn:aws:iam::123456789012:role/profiler"))
+			Expect(assumeRole.Get("RoleSessionName")).To(Equal("ci"))
+			Expect(assumeRole.Get("ExternalId")).To(Equal("ext-42"))
+			Expect(assumeRole.Get("SerialNumber")).To(BeEmpty())
+		})
+	})
+
+	Context("Consul configuration", func() {
+		AfterEach(func() {
+			for _, env := range []string{"CONSUL_HTTP_ADDR", "CONSUL_HTTP_TOKEN", "CONSUL_NAMESPACE"} {
+				os.Unsetenv(env)
+			}
+			for _, key := range []string{"consulAddress", "consulNamespace", "consulCAFile", "consulTLSServerName"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should not be configured without address", func() {
+			Expect(consul.Configured()).To(BeFalse())
+		})
+
+		It("should fall back on the Consul environment variables", func() {
+			os.Setenv("CONSUL_HTTP_ADDR", "https://consul.example.com:8501")
+			os.Setenv("CONSUL_HTTP_TOKEN", "env-token")
+			os.Setenv("CONSUL_NAMESPACE", "team-a")
+
+			Expect(consul.Configured()).To(BeTrue())
+			config := consul.NewConsulConfig()
+			Expect(config.Address).To(Equal("https://consul.example.com:8501"))
+			Expect(config.Token).To(Equal("env-token"))
+			Expect(config.Namespace).To(Equal("team-a"))
+		})
+
+		It("should prefer the profiler configuration", func() {
+			os.Setenv("CONSUL_HTTP_ADDR", "consul.example.com:8500")
+			os.Setenv("CONSUL_NAMESPACE", "team-a")
+			viper.Set("consulAddress", "10.0.0.1:8500")
+			viper.Set("consulNamespace", "team-b")
+			viper.Set("consulCAFile", "/etc/consul/ca.pem")
+			viper.Set("consulTLSServerName", "consul.internal")
+
+			Expect(consul.Configured()).To(BeTrue())
+			config := consul.NewConsulConfig()
+			Expect(config.Address).To(Equal("10.0.0.1:8500"))
+			Expect(config.Namespace).To(Equal("team-b"))
+			Expect(config.TLSConfig.CAFile).To(Equal("/etc/consul/ca.pem"))
+			Expect(config.TLSConfig.Address).To(Equal("consul.internal"))
+		})
+	})
+
+	Context("Consul backend", func() {
+		var fake *consulfake.KV
+
+		BeforeEach(func() {
+			fake = consulfake.New()
+			consul.SetKV(fake)
+			fake.Put(&api.KVPair{Key: "profiler/"}, nil)
+			fake.Put(&api.KVPair{Key: "profiler/dev", Value: []byte("profile_name: dev\nFOO: bar\n")}, nil)
+			fake.Put(&api.KVPair{Key: "profiler/prod", Value: []byte("profile_name: prod\n")}, nil)
+		})
+
+		AfterEach(func() {
+			consul.SetKV(nil)
+		})
+
+		It("should list the profiles", func() {
+			profiles, err := consul.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+		})
+
+		It("should get the profile variables", func() {
+			vars, err := consul.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+			_, err = consul.GetProfile("missing")
+			Expect(err).To(Not(BeNil()))
+		})
+
+		It("should store the metadata apart from the variables", func() {
+			m := meta.Meta{Description: "Dev cluster", Tags: []string{"dev"}}
+			Expect(consul.SetMeta("dev", m)).To(Succeed())
+
+			stored, err := consul.GetMeta("dev")
+			Expect(err).To(BeNil())
+			Expect(stored).To(Equal(m))
+
+			profiles, err := consul.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+		})
+
+		It("should delete a profile and its metadata", func() {
+			Expect(consul.SetMeta("prod", meta.Meta{Owner: "platform"})).To(Succeed())
+			Expect(consul.DeleteProfile("prod")).To(Succeed())
+
+			exist, err := consul.ProfileExist("prod")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+
+			m, err := consul.GetMeta("prod")
+			Expect(err).To(BeNil())
+			Expect(m.IsEmpty()).To(BeTrue())
+		})
+
+		It("should remove a single variable", func() {
+			Expect(consul.RemoveKVPair("dev", "FOO")).To(Succeed())
+
+			vars, err := consul.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev"}))
+
+			Expect(consul.RemoveKVPair("dev", "FOO")).To(Not(Succeed()))
+		})
+
+		It("should add several variables to a profile", func() {
+			Expect(consul.AddKVPair("dev", []string{"A", "1", "B", "2", "FOO", "baz"})).To(Succeed())
+
+			vars, err := consul.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{
+				"profile_name": "dev",
+				"A":            "1",
+				"B":            "2",
+				"FOO":          "baz",
+			}))
+		})
+
+		It("should create an empty profile", func() {
+			Expect(consul.AddKVPair("new", []string{})).To(Succeed())
+
+			profiles, err := consul.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(ContainElement("new"))
+
+			vars, err := consul.GetProfile("new")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "new"}))
+		})
+
+		It("should refuse a variable without value", func() {
+			Expect(consul.AddKVPair("dev", []string{"A"})).To(Not(Succeed()))
+		})
+
+		It("should not clobber concurrent updates", func() {
+			consul.SetKV(&concurrentKV{KV: fake, conflicts: 2})
+			Expect(consul.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+
+			vars, err := consul.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("A", "1"))
+			Expect(vars).To(HaveKey("TEAMMATE_0"))
+			Expect(vars).To(HaveKey("TEAMMATE_1"))
+		})
+
+		It("should give up when the profile keeps changing", func() {
+			consul.SetKV(&concurrentKV{KV: fake, conflicts: 10})
+			Expect(consul.AddKVPair("dev", []string{"A", "1"})).To(MatchError(consul.ErrConflict))
+		})
+
+		It("should watch the profile changes", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			updates := make(chan []consul.Change, 2)
+			done := make(chan error)
+			go func() {
+				done <- consul.Watch(ctx, "dev", func(vars map[string]string, changes []consul.Change) {
+					updates <- changes
+				})
+			}()
+
+			Eventually(updates).Should(Receive(BeNil()))
+
+			// An update of another profile is not a change:
+			Expect(consul.AddKVPair("prod", []string{"A", "1"})).To(Succeed())
+			Expect(consul.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+			Eventually(updates).Should(Receive(Equal([]consul.Change{{Key: "A", Kind: consul.Added}})))
+
+			Expect(consul.RemoveKVPair("dev", "FOO")).To(Succeed())
+			Eventually(updates).Should(Receive(Equal([]consul.Change{{Key: "FOO", Kind: consul.Removed}})))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should keep the last variables of a deleted profile", func() {
+			output := gbytes.NewBuffer()
+			consul.SetWatchErrorOutput(output)
+			defer consul.SetWatchErrorOutput(os.Stderr)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			updates := make(chan []consul.Change, 2)
+			done := make(chan error)
+			go func() {
+				done <- consul.Watch(ctx, "dev", func(vars map[string]string, changes []consul.Change) {
+					updates <- changes
+				})
+			}()
+
+			Eventually(updates).Should(Receive(BeNil()))
+
+			// A profile sharing its prefix is not a change:
+			Expect(consul.AddKVPair("dev2", []string{"A", "1"})).To(Succeed())
+			Consistently(updates, 200*time.Millisecond).ShouldNot(Receive())
+
+			Expect(consul.DeleteProfile("dev")).To(Succeed())
+			Eventually(output).Should(gbytes.Say("The dev profile has been deleted, keeping its last variables"))
+			Consistently(updates, 200*time.Millisecond).ShouldNot(Receive())
+
+			fake.Put(&api.KVPair{Key: "profiler/dev", Value: []byte("profile_name: dev\nFOO: baz\n")}, nil)
+			Eventually(updates).Should(Receive(Equal([]consul.Change{{Key: "FOO", Kind: consul.Updated}})))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should report the watch errors and keep watching", func() {
+			output := gbytes.NewBuffer()
+			consul.SetWatchErrorOutput(output)
+			defer consul.SetWatchErrorOutput(os.Stderr)
+			consul.SetKV(&unreachableKV{KV: fake, failures: 1})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			updates := make(chan []consul.Change, 2)
+			done := make(chan error)
+			go func() {
+				done <- consul.Watch(ctx, "dev", func(vars map[string]string, changes []consul.Change) {
+					updates <- changes
+				})
+			}()
+
+			Eventually(output).Should(gbytes.Say("Error watching the dev profile: connection refused, retrying in 1s"))
+			Eventually(updates, 3*time.Second).Should(Receive(BeNil()))
+
+			// A bad revision is skipped, the next one being watched:
+			fake.Put(&api.KVPair{Key: "profiler/dev", Value: []byte("FOO: [")}, nil)
+			Eventually(output).Should(gbytes.Say("Ignoring the revision [0-9]+ of the dev profile"))
+			Consistently(updates, 200*time.Millisecond).ShouldNot(Receive())
+
+			fake.Put(&api.KVPair{Key: "profiler/dev", Value: []byte("profile_name: dev\nFOO: baz\n")}, nil)
+			Eventually(updates).Should(Receive(Equal([]consul.Change{{Key: "FOO", Kind: consul.Updated}})))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		Context("with the per-key layout", func() {
+			BeforeEach(func() {
+				viper.Set("consulLayout", consul.LayoutPerKey)
+			})
+
+			AfterEach(func() {
+				viper.Set("consulLayout", "")
+			})
+
+			It("should watch the variables keys", func() {
+				Expect(consul.AddKVPair("staging", []string{"A", "1"})).To(Succeed())
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				updates := make(chan []consul.Change, 2)
+				done := make(chan error)
+				go func() {
+					done <- consul.Watch(ctx, "staging", func(vars map[string]string, changes []consul.Change) {
+						updates <- changes
+					})
+				}()
+
+				Eventually(updates).Should(Receive(BeNil()))
+
+				Expect(consul.AddKVPair("staging", []string{"B", "2"})).To(Succeed())
+				Eventually(updates).Should(Receive(Equal([]consul.Change{{Key: "B", Kind: consul.Added}})))
+
+				cancel()
+				Eventually(done).Should(Receive(BeNil()))
+			})
+
+			It("should store one key per variable", func() {
+				Expect(consul.AddKVPair("staging", []string{"A", "1", "B", "2"})).To(Succeed())
+
+				kv, _, err := fake.Get("profiler/staging/A", nil)
+				Expect(err).To(BeNil())
+				Expect(string(kv.Value)).To(Equal("1"))
+
+				vars, err := consul.GetProfile("staging")
+				Expect(err).To(BeNil())
+				Expect(vars).To(Equal(map[string]string{"profile_name": "staging", "A": "1", "B": "2"}))
+
+				profiles, err := consul.ListProfiles()
+				Expect(err).To(BeNil())
+				Expect(profiles).To(Equal([]string{"dev", "prod", "staging"}))
+			})
+
+			It("should ignore the metadata and the other profiles", func() {
+				Expect(consul.AddKVPair("staging", []string{"A", "1"})).To(Succeed())
+				Expect(consul.AddKVPair("staging2", []string{"B", "2"})).To(Succeed())
+				Expect(consul.SetMeta("staging", meta.Meta{Owner: "platform"})).To(Succeed())
+
+				vars, err := consul.GetProfile("staging")
+				Expect(err).To(BeNil())
+				Expect(vars).To(Equal(map[string]string{"profile_name": "staging", "A": "1"}))
+			})
+
+			It("should keep using the layout of the existing profiles", func() {
+				Expect(consul.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+
+				kv, _, err := fake.Get("profiler/dev/A", nil)
+				Expect(err).To(BeNil())
+				Expect(kv).To(BeNil())
+
+				vars, err := consul.GetProfile("dev")
+				Expect(err).To(BeNil())
+				Expect(vars).To(HaveKeyWithValue("A", "1"))
+			})
+
+			It("should remove a variable and delete the profile", func() {
+				Expect(consul.AddKVPair("staging", []string{"A", "1", "B", "2"})).To(Succeed())
+				Expect(consul.RemoveKVPair("staging", "A")).To(Succeed())
+
+				vars, err := consul.GetProfile("staging")
+				Expect(err).To(BeNil())
+				Expect(vars).To(Equal(map[string]string{"profile_name": "staging", "B": "2"}))
+
+				Expect(consul.DeleteProfile("staging")).To(Succeed())
+				pairs, _, err := fake.List("profiler/staging", nil)
+				Expect(err).To(BeNil())
+				Expect(pairs).To(BeEmpty())
+			})
+
+			It("should retry the transaction on concurrent updates", func() {
+				Expect(consul.AddKVPair("staging", []string{"A", "1"})).To(Succeed())
+
+				consul.SetKV(&concurrentKV{KV: fake, conflicts: 2})
+				Expect(consul.AddKVPair("staging", []string{"A", "2"})).To(Succeed())
+
+				vars, err := consul.GetProfile("staging")
+				Expect(err).To(BeNil())
+				Expect(vars).To(HaveKeyWithValue("A", "2"))
+			})
+
+			It("should refuse the variables that can't be keys", func() {
+				Expect(consul.AddKVPair("staging", []string{"A/B", "1"})).To(Not(Succeed()))
+			})
+
+			It("should migrate the profiles in both directions", func() {
+				Expect(consul.SetMeta("dev", meta.Meta{Owner: "platform"})).To(Succeed())
+
+				migrated, err := consul.Migrate("dev", consul.LayoutPerKey)
+				Expect(err).To(BeNil())
+				Expect(migrated).To(BeTrue())
+
+				kv, _, err := fake.Get("profiler/dev", nil)
+				Expect(err).To(BeNil())
+				Expect(kv).To(BeNil())
+				kv, _, err = fake.Get("profiler/dev/FOO", nil)
+				Expect(err).To(BeNil())
+				Expect(string(kv.Value)).To(Equal("bar"))
+
+				migrated, err = consul.Migrate("dev", consul.LayoutPerKey)
+				Expect(err).To(BeNil())
+				Expect(migrated).To(BeFalse())
+
+				migrated, err = consul.Migrate("dev", consul.LayoutBlob)
+				Expect(err).To(BeNil())
+				Expect(migrated).To(BeTrue())
+
+				pairs, _, err := fake.List("profiler/dev/", nil)
+				Expect(err).To(BeNil())
+				Expect(pairs).To(HaveLen(1))
+				Expect(pairs[0].Key).To(Equal("profiler/dev/_meta"))
+
+				vars, err := consul.GetProfile("dev")
+				Expect(err).To(BeNil())
+				Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+				_, err = consul.Migrate("missing", consul.LayoutBlob)
+				Expect(err).To(Not(BeNil()))
+			})
+
+			It("should refuse to migrate the profiles too large for a transaction", func() {
+				var kvs []string
+				for i := 0; i < 63; i++ {
+					kvs = append(kvs, fmt.Sprintf("VAR_%d", i), "x")
+				}
+				Expect(consul.AddKVPair("large", kvs)).To(Succeed())
+
+				// 64 variables with profile_name:
+				err := consul.CheckMigration("large", consul.LayoutBlob)
+				Expect(err).To(MatchError(ContainSubstring("64 variables, too many to be converted in a single Consul transaction")))
+
+				_, err = consul.Migrate("large", consul.LayoutBlob)
+				Expect(err).To(Not(BeNil()))
+
+				// Nothing has been written:
+				kv, _, err := fake.Get("profiler/large", nil)
+				Expect(err).To(BeNil())
+				Expect(kv).To(BeNil())
+				vars, err := consul.GetProfile("large")
+				Expect(err).To(BeNil())
+				Expect(vars).To(HaveLen(64))
+
+				Expect(consul.RemoveKVPair("large", "VAR_0")).To(Succeed())
+				Expect(consul.CheckMigration("large", consul.LayoutBlob)).To(Succeed())
+				Expect(consul.CheckMigration("large", consul.LayoutPerKey)).To(Succeed())
+			})
+		})
+
+		It("should report the API errors", func() {
+			fake.Err = errors.New("connection refused")
+
+			_, err := consul.ListProfiles()
+			Expect(err).To(MatchError("connection refused"))
+		})
+	})
+
+	Context("Git remotes", func() {
+		var tmp, origin string
+
+		BeforeEach(func() {
+			var err error
+			tmp, err = ioutil.TempDir("", "profiler-remotes")
+			Expect(err).To(BeNil())
+			for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+				os.Setenv(v, "Profiler Test")
+			}
+			for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+				os.Setenv(v, "test@profiler.local")
+			}
+
+			// A bare repository seeded with a profile:
+			origin = tmp + "/origin.git"
+			runGit(tmp, "init", "--quiet", "--bare", "-b", "main", origin)
+			runGit(tmp, "clone", "--quiet", origin, "seed")
+			Expect(ioutil.WriteFile(tmp+"/seed/dev.yml", []byte("# Shared dev profile\nprofile_name: dev\nFOO: bar\n"), 0644)).To(Succeed())
+			runGit(tmp+"/seed", "add", "dev.yml")
+			runGit(tmp+"/seed", "commit", "--quiet", "-m", "Add dev")
+			runGit(tmp+"/seed", "push", "--quiet", "origin", "main")
+
+			viper.Set("remotesFolder", tmp+"/remotes")
+			Expect(remote.Add("team", origin)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			viper.Set("remotesFolder", "")
+			os.RemoveAll(tmp)
+		})
+
+		It("should list the remotes and their profiles", func() {
+			remotes, err := remote.List()
+			Expect(err).To(BeNil())
+			Expect(remotes).To(Equal([]remote.Remote{{Name: "team", URL: origin}}))
+
+			profiles, err := remote.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"team/dev"}))
+
+			Expect(remote.Add("team", origin)).To(Not(Succeed()))
+		})
+
+		It("should read the remote profiles", func() {
+			Expect(remote.IsRemoteProfile("team/dev")).To(BeTrue())
+			Expect(remote.IsRemoteProfile("other/dev")).To(BeFalse())
+			Expect(remote.IsRemoteProfile("dev")).To(BeFalse())
+
+			vars := profile.GetProfile(profilesPath, "team/dev")
+			Expect(vars).To(Equal(profile.KeyValueMap{"profile_name": "dev", "FOO": "bar"}))
+
+			_, err := remote.ProfileFile("team/missing")
+			Expect(err).To(Not(BeNil()))
+		})
+
+		It("should reject the profile names leaving the remote", func() {
+			for _, name := range []string{"team/../../x", "team/..", "team/sub/dev", "../team/dev"} {
+				Expect(remote.IsRemoteProfile(name)).To(BeFalse(), name)
+
+				_, err := remote.ProfileFile(name)
+				Expect(err).To(Not(BeNil()), name)
+
+				_, err = remote.SetVariable(name, "A", "1")
+				Expect(err).To(Not(BeNil()), name)
+			}
+
+			Expect(remote.Add("..", origin)).To(MatchError("invalid remote name .."))
+		})
+
+		It("should pull the updates", func() {
+			Expect(ioutil.WriteFile(tmp+"/seed/prod.yml", []byte("profile_name: prod\n"), 0644)).To(Succeed())
+			runGit(tmp+"/seed", "add", "prod.yml")
+			runGit(tmp+"/seed", "commit", "--quiet", "-m", "Add prod")
+			runGit(tmp+"/seed", "push", "--quiet", "origin", "main")
+
+			Expect(remote.Update("")).To(Succeed())
+
+			profiles, err := remote.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"team/dev", "team/prod"}))
+		})
+
+		It("should commit the changes on a branch", func() {
+			branch, err := remote.SetVariable("team/dev", "A", "1")
+			Expect(err).To(BeNil())
+			Expect(branch).To(Equal("profiler/dev"))
+
+			_, err = remote.RemoveVariable("team/dev", "FOO")
+			Expect(err).To(BeNil())
+			_, err = remote.RemoveVariable("team/dev", "FOO")
+			Expect(err).To(Not(Succeed()))
+
+			// The default branch is left untouched until the change is merged:
+			vars := profile.GetProfile(profilesPath, "team/dev")
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+
+			Expect(runGit(origin, "log", "--format=%s", "profiler/dev")).To(Equal(
+				"Remove FOO from the dev profile\nSet A in the dev profile\nAdd dev",
+			))
+			Expect(runGit(origin, "show", "profiler/dev:dev.yml")).To(Equal(
+				"# Shared dev profile\nprofile_name: dev\nA: \"1\"",
+			))
+		})
+
+		It("should create and delete remote profiles", func() {
+			_, err := remote.SetVariable("team/staging", "A", "1")
+			Expect(err).To(BeNil())
+			Expect(runGit(origin, "show", "profiler/staging:staging.yml")).To(Equal("profile_name: staging\nA: \"1\""))
+
+			_, err = remote.DeleteProfile("team/dev")
+			Expect(err).To(BeNil())
+			Expect(runGit(origin, "ls-tree", "--name-only", "profiler/dev")).To(BeEmpty())
+		})
+	})
+
+	Context("S3 backend", func() {
+		var fake *s3fake.S3
+
+		BeforeEach(func() {
+			fake = s3fake.New()
+			s3.SetService(fake)
+			viper.Set("s3Bucket", "profiles")
+			Expect(s3.AddKVPair("dev", []string{"FOO", "bar"})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			s3.SetService(nil)
+			for _, key := range []string{"s3Bucket", "s3Prefix", "s3EncryptionKey", "s3ServerSideEncryption", "s3KmsKeyId"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should store each profile as an object under the prefix", func() {
+			Expect(s3.AddKVPair("prod", []string{})).To(Succeed())
+			fake.Put("profiles", "profiler/nested/ignored.txt", []byte("{}"), nil)
+
+			profiles, err := s3.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+
+			o := fake.Get("profiles", "profiler/dev.yml")
+			Expect(o).To(Not(BeNil()))
+			Expect(string(o.Body)).To(ContainSubstring("FOO: bar"))
+
+			viper.Set("s3Prefix", "/team/")
+			profiles, err = s3.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(BeEmpty())
+		})
+
+		It("should add and remove variables", func() {
+			Expect(s3.AddKVPair("dev", []string{"A", "1", "FOO", "baz"})).To(Succeed())
+			Expect(s3.RemoveKVPair("dev", "A")).To(Succeed())
+			Expect(s3.RemoveKVPair("dev", "A")).To(MatchError("A not found in the dev profile"))
+			Expect(s3.AddKVPair("dev", []string{"B"})).To(MatchError("Missing value for the variable B"))
+
+			vars, err := s3.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "baz"}))
+
+			Expect(s3.DeleteProfile("dev")).To(Succeed())
+			exist, err := s3.ProfileExist("dev")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+			_, err = s3.GetProfile("dev")
+			Expect(err).To(MatchError("profile dev not found"))
+		})
+
+		It("should request the server-side encryption", func() {
+			viper.Set("s3ServerSideEncryption", "aws:kms")
+			viper.Set("s3KmsKeyId", "alias/profiler")
+			Expect(s3.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+
+			o := fake.Get("profiles", "profiler/dev.yml")
+			Expect(o.ServerSideEncryption).To(Equal("aws:kms"))
+			Expect(o.SSEKMSKeyID).To(Equal("alias/profiler"))
+		})
+
+		It("should encrypt the profiles client-side", func() {
+			viper.Set("s3EncryptionKey", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+			Expect(s3.AddKVPair("dev", []string{"SECRET", "s3cr3t"})).To(Succeed())
+
+			o := fake.Get("profiles", "profiler/dev.yml")
+			Expect(o.Metadata).To(HaveKeyWithValue("Profiler-Encryption", "aes-256-gcm"))
+			Expect(string(o.Body)).To(Not(ContainSubstring("s3cr3t")))
+
+			vars, err := s3.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("SECRET", "s3cr3t"))
+
+			viper.Set("s3EncryptionKey", "")
+			_, err = s3.GetProfile("dev")
+			Expect(err).To(MatchError(ContainSubstring("the dev profile is encrypted")))
+
+			viper.Set("s3EncryptionKey", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+			_, err = s3.GetProfile("dev")
+			Expect(err).To(MatchError(ContainSubstring("can't decrypt the profile")))
+		})
+
+		It("should retry the writes refused by a concurrent modification", func() {
+			conflict := awserr.New("PreconditionFailed", "conflict", nil)
+			fake.FailNext("PutObject", conflict)
+			Expect(s3.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+			Expect(fake.Calls["PutObject"]).To(Equal(3))
+
+			fake.FailNext("PutObject", conflict, conflict, conflict, conflict, conflict)
+			Expect(s3.AddKVPair("dev", []string{"B", "2"})).To(MatchError(s3.ErrConflict))
+		})
+
+		It("should not overwrite a profile modified since it has been read", func() {
+			s3.SetService(&concurrentS3{S3: fake, conflicts: 2})
+			Expect(s3.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+
+			vars, err := s3.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{
+				"profile_name": "dev",
+				"FOO":          "bar",
+				"A":            "1",
+				"TEAMMATE_1":   "x",
+				"TEAMMATE_0":   "x",
+			}))
+		})
+	})
+
+	Context("Secrets Manager backend", func() {
+		var fake *secretsmanagerfake.SecretsManager
+
+		BeforeEach(func() {
+			fake = secretsmanagerfake.New()
+			secretsmanager.SetService(fake)
+			Expect(secretsmanager.AddKVPair("dev", []string{"FOO", "bar"})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			secretsmanager.SetService(nil)
+			for _, key := range []string{"secretsManagerPrefix", "secretsManagerTag", "secretsManagerKmsKeyId"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should store each profile as a JSON secret", func() {
+			secret := fake.Get("profiler/dev")
+			Expect(secret).To(Not(BeNil()))
+			Expect(secret.Versions["AWSCURRENT"]).To(MatchJSON(`{"profile_name": "dev", "FOO": "bar"}`))
+
+			vars, err := secretsmanager.GetProfile("dev", "")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+			_, err = secretsmanager.GetProfile("missing", "")
+			Expect(err).To(MatchError("profile missing not found"))
+		})
+
+		It("should list the profiles by name prefix and tag", func() {
+			viper.Set("secretsManagerTag", "team=platform")
+			viper.Set("secretsManagerKmsKeyId", "alias/profiler")
+			for _, p := range []string{"prod", "staging", "qa"} {
+				Expect(secretsmanager.AddKVPair(p, []string{})).To(Succeed())
+			}
+			Expect(fake.Get("profiler/prod").Tags).To(HaveKeyWithValue("team", "platform"))
+			Expect(fake.Get("profiler/prod").KmsKeyID).To(Equal("alias/profiler"))
+
+			profiles, err := secretsmanager.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"prod", "qa", "staging"}))
+
+			viper.Set("secretsManagerTag", "")
+			profiles, err = secretsmanager.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod", "qa", "staging"}))
+			Expect(fake.Calls["ListSecrets"]).To(Equal(2))
+
+			viper.Set("secretsManagerPrefix", "other/")
+			profiles, err = secretsmanager.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(BeEmpty())
+		})
+
+		It("should not overwrite a profile modified since it has been read", func() {
+			secretsmanager.SetService(&concurrentSecretsManager{SecretsManager: fake, conflicts: 2})
+			Expect(secretsmanager.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+
+			vars, err := secretsmanager.GetProfile("dev", "")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{
+				"profile_name": "dev",
+				"FOO":          "bar",
+				"A":            "1",
+				"TEAMMATE_1":   "x",
+				"TEAMMATE_0":   "x",
+			}))
+
+			secretsmanager.SetService(&concurrentSecretsManager{SecretsManager: fake, conflicts: 5})
+			Expect(secretsmanager.AddKVPair("dev", []string{"B", "2"})).To(MatchError(secretsmanager.ErrConflict))
+		})
+
+		It("should keep the previous version of the profile", func() {
+			Expect(secretsmanager.AddKVPair("dev", []string{"FOO", "baz"})).To(Succeed())
+
+			vars, err := secretsmanager.GetProfile("dev", secretsmanager.StagePrevious)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+
+			vars, err = secretsmanager.GetProfile("dev", secretsmanager.StageCurrent)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("FOO", "baz"))
+
+			Expect(secretsmanager.AddKVPair("new", []string{})).To(Succeed())
+			_, err = secretsmanager.GetProfile("new", secretsmanager.StagePrevious)
+			Expect(err).To(MatchError("profile new has no AWSPREVIOUS version"))
+		})
+
+		It("should remove variables and delete the profiles", func() {
+			Expect(secretsmanager.RemoveKVPair("dev", "FOO")).To(Succeed())
+			Expect(secretsmanager.RemoveKVPair("dev", "FOO")).To(MatchError("FOO not found in the dev profile"))
+			Expect(secretsmanager.AddKVPair("dev", []string{"B"})).To(MatchError("Missing value for the variable B"))
+
+			keys, err := secretsmanager.ShowProfile("dev", "")
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"profile_name"}))
+
+			Expect(secretsmanager.DeleteProfile("dev", false)).To(Succeed())
+			exist, err := secretsmanager.ProfileExist("dev")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+			Expect(fake.Get("profiler/dev")).To(Not(BeNil()))
+
+			profiles, err := secretsmanager.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(BeEmpty())
+
+			Expect(secretsmanager.AddKVPair("prod", []string{})).To(Succeed())
+			Expect(secretsmanager.DeleteProfile("prod", true)).To(Succeed())
+			Expect(fake.Get("profiler/prod")).To(BeNil())
+		})
+
+		It("should report the API errors", func() {
+			fake.FailNext("GetSecretValue", awserr.New("AccessDeniedException", "denied", nil))
+
+			_, err := secretsmanager.GetProfile("dev", "")
+			Expect(err).To(MatchError(ContainSubstring("AccessDeniedException")))
+		})
+	})
+
+	Context("pass backend", func() {
+		var store string
+
+		BeforeEach(func() {
+			if _, err := exec.LookPath("gpg"); err != nil {
+				Skip("gpg is not installed")
+			}
+			setupGnuPG()
+
+			var err error
+			store, err = ioutil.TempDir("", "profiler-password-store")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(store+"/.gpg-id", []byte(gpgTestID+"\n"), 0600)).To(Succeed())
+			viper.Set("passwordStoreDir", store)
+			// Go through gpg even if pass is installed:
+			viper.Set("passUseGpg", true)
+
+			Expect(pass.AddKVPair("dev", []string{"FOO", "bar", "DB_PASSWORD", "s3cr3t"})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(store)
+			viper.Set("passwordStoreDir", "")
+			viper.Set("passUseGpg", false)
+			viper.Set("passPrefix", "")
+		})
+
+		It("should store each variable as an encrypted entry", func() {
+			Expect(pass.Configured()).To(BeTrue())
+
+			b, err := ioutil.ReadFile(store + "/profiler/dev/DB_PASSWORD.gpg")
+			Expect(err).To(BeNil())
+			Expect(string(b)).To(Not(ContainSubstring("s3cr3t")))
+
+			keys, err := pass.ShowProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"DB_PASSWORD", "FOO"}))
+
+			vars, err := pass.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar", "DB_PASSWORD": "s3cr3t"}))
+		})
+
+		It("should only use the first line of the entries", func() {
+			command := exec.Command(
+				"gpg", "--batch", "--quiet", "--yes", "--encrypt", "--recipient", gpgTestID,
+				"--output", store+"/profiler/dev/TOKEN.gpg",
+			)
+			command.Stdin = strings.NewReader("t0k3n\nurl: https://example.com\n")
+			out, err := command.CombinedOutput()
+			Expect(err).To(BeNil(), string(out))
+
+			vars, err := pass.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("TOKEN", "t0k3n"))
+		})
+
+		It("should list, remove and delete the profiles", func() {
+			Expect(pass.AddKVPair("prod", []string{})).To(Succeed())
+			Expect(pass.AddKVPair("prod", []string{"A/B", "1"})).To(MatchError("invalid variable name A/B"))
+			Expect(pass.AddKVPair("prod", []string{"A", "1\n2"})).To(MatchError("the value of A can't hold several lines"))
+
+			profiles, err := pass.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+
+			Expect(pass.RemoveKVPair("dev", "FOO")).To(Succeed())
+			Expect(pass.RemoveKVPair("dev", "FOO")).To(MatchError("FOO not found in the dev profile"))
+
+			Expect(pass.DeleteProfile("prod")).To(Succeed())
+			exist, err := pass.ProfileExist("prod")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+
+			_, err = pass.GetProfile("prod")
+			Expect(err).To(MatchError("profile prod not found"))
+		})
+
+		It("should fail to encrypt without recipients", func() {
+			Expect(os.Remove(store + "/.gpg-id")).To(Succeed())
+
+			err := pass.AddKVPair("dev", []string{"A", "1"})
+			Expect(err).To(MatchError(ContainSubstring("no .gpg-id found")))
+		})
+	})
+
+	Context("Kubernetes backend", func() {
+		var clientset *k8sfake.Clientset
+
+		BeforeEach(func() {
+			labels := map[string]string{"profiler/profile": "dev"}
+			clientset = k8sfake.NewSimpleClientset(
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "dev-env", Namespace: "platform", Labels: labels},
+					Data:       map[string]string{"FOO": "bar", "DB_HOST": "db.dev"},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "dev-credentials", Namespace: "platform", Labels: labels},
+					Data:       map[string][]byte{"DB_PASSWORD": []byte("s3cr3t"), "FOO": []byte("from-secret")},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "prod-env", Namespace: "platform", Labels: map[string]string{"profiler/profile": "prod"}},
+					Data:       map[string]string{"FOO": "prod"},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", Namespace: "platform"},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"profiler/profile": "other"}},
+				},
+			)
+			k8s.SetClientset(clientset)
+			viper.Set("k8sNamespace", "platform")
+		})
+
+		AfterEach(func() {
+			k8s.SetClientset(nil)
+			for _, key := range []string{"k8sNamespace", "k8sWritable", "k8sWriteKind", "k8sProfileLabel"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should list the labeled profiles of the namespace", func() {
+			profiles, err := k8s.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+
+			viper.Set("k8sProfileLabel", "team")
+			profiles, err = k8s.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(BeEmpty())
+		})
+
+		It("should merge the ConfigMaps and the decoded Secrets of a profile", func() {
+			vars, err := k8s.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{
+				"profile_name": "dev",
+				"FOO":          "from-secret",
+				"DB_HOST":      "db.dev",
+				"DB_PASSWORD":  "s3cr3t",
+			}))
+
+			_, err = k8s.GetProfile("other")
+			Expect(err).To(MatchError("profile other not found"))
+		})
+
+		It("should be read-only by default", func() {
+			Expect(k8s.AddKVPair("dev", []string{"A", "1"})).To(MatchError(k8s.ErrReadOnly))
+			Expect(k8s.RemoveKVPair("dev", "FOO")).To(MatchError(k8s.ErrReadOnly))
+			Expect(k8s.DeleteProfile("dev")).To(MatchError(k8s.ErrReadOnly))
+		})
+
+		It("should write the profiles when enabled", func() {
+			viper.Set("k8sWritable", true)
+			Expect(k8s.AddKVPair("staging", []string{"A", "1"})).To(Succeed())
+			Expect(k8s.AddKVPair("staging", []string{"B", "2"})).To(Succeed())
+
+			secret, err := clientset.CoreV1().Secrets("platform").Get(context.TODO(), "profiler-staging", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(secret.Labels).To(HaveKeyWithValue("profiler/profile", "staging"))
+			Expect(secret.Data).To(Equal(map[string][]byte{"A": []byte("1"), "B": []byte("2")}))
+
+			viper.Set("k8sWriteKind", "configmap")
+			Expect(k8s.AddKVPair("qa", []string{"C", "3"})).To(Succeed())
+			configMap, err := clientset.CoreV1().ConfigMaps("platform").Get(context.TODO(), "profiler-qa", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(configMap.Data).To(Equal(map[string]string{"C": "3"}))
+
+			profiles, err := k8s.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod", "qa", "staging"}))
+		})
+
+		It("should remove the variables and delete the profiles when enabled", func() {
+			viper.Set("k8sWritable", true)
+			Expect(k8s.RemoveKVPair("dev", "FOO")).To(Succeed())
+			Expect(k8s.RemoveKVPair("dev", "FOO")).To(MatchError("FOO not found in the dev profile"))
+
+			keys, err := k8s.ShowProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"DB_HOST", "DB_PASSWORD", "profile_name"}))
+
+			Expect(k8s.DeleteProfile("dev")).To(Succeed())
+			exist, err := k8s.ProfileExist("dev")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+
+			_, err = clientset.CoreV1().ConfigMaps("platform").Get(context.TODO(), "unlabeled", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("profiler server and HTTP backend", func() {
+		var folder string
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			log.SetOutput(ioutil.Discard)
+
+			var err error
+			folder, err = ioutil.TempDir("", "profiler-serve")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(folder+"/.dev.yml", []byte("profile_name: dev\n# The foo\nFOO: bar\n_meta:\n  owner: platform\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(folder+"/.prod.yaml", []byte("profile_name: prod\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(folder+"/.team-a.yml", []byte("profile_name: team-a\n"), 0644)).To(Succeed())
+
+			server = httptest.NewTLSServer(&rest.Server{
+				ProfilesFolder: folder,
+				Tokens: []rest.Token{
+					{Name: "admin", Token: "admin-token", Write: []string{"*"}},
+					{Name: "ci", TokenSha256: "948b8c2427cd29047839b8e4a27a08763f8befbafa86be5cce8e46217d75e58a", Read: []string{"dev", "team-*"}},
+				},
+			})
+			viper.Set("httpAddress", server.URL)
+			viper.Set("httpToken", "admin-token")
+			viper.Set("httpSkipVerify", true)
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(folder)
+			log.SetOutput(os.Stderr)
+			for _, key := range []string{"httpAddress", "httpToken", "httpSkipVerify"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should serve the local profiles", func() {
+			profiles, err := rest.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod", "team-a"}))
+
+			vars, err := rest.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+			exist, err := rest.ProfileExist("missing")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+			_, err = rest.GetProfile("missing")
+			Expect(err).To(MatchError("profile missing not found"))
+		})
+
+		It("should serve the metadata of the profiles", func() {
+			m, err := rest.GetMeta("dev")
+			Expect(err).To(BeNil())
+			Expect(m.Owner).To(Equal("platform"))
+
+			m, err = rest.GetMeta("prod")
+			Expect(err).To(BeNil())
+			Expect(m.IsEmpty()).To(BeTrue())
+		})
+
+		It("should write the profiles files in place", func() {
+			Expect(rest.AddKVPair("dev", []string{"FOO", "baz", "NEW", "1"})).To(Succeed())
+			Expect(rest.RemoveKVPair("dev", "NEW")).To(Succeed())
+			Expect(rest.RemoveKVPair("dev", "NEW")).To(MatchError("NEW not found in the dev profile"))
+			Expect(rest.AddKVPair("dev", []string{"_meta", "x"})).To(MatchError(ContainSubstring("400 invalid variable name _meta")))
+
+			b, err := ioutil.ReadFile(folder + "/.dev.yml")
+			Expect(err).To(BeNil())
+			Expect(string(b)).To(Equal("profile_name: dev\n# The foo\nFOO: baz\n_meta:\n  owner: platform\n"))
+
+			Expect(rest.AddKVPair("staging", []string{})).To(Succeed())
+			vars, err := rest.GetProfile("staging")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "staging"}))
+
+			Expect(rest.DeleteProfile("prod")).To(Succeed())
+			Expect(folder + "/.prod.yaml").To(Not(BeAnExistingFile()))
+			Expect(rest.DeleteProfile("prod")).To(MatchError("profile prod not found"))
+		})
+
+		It("should enforce the tokens ACLs", func() {
+			viper.Set("httpToken", "ci-token")
+
+			profiles, err := rest.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "team-a"}))
+
+			_, err = rest.GetProfile("prod")
+			Expect(err).To(MatchError("profiler server: 403 access to the prod profile denied"))
+
+			err = rest.AddKVPair("dev", []string{"A", "1"})
+			Expect(err).To(MatchError("profiler server: 403 access to the dev profile denied"))
+
+			viper.Set("httpToken", "wrong")
+			_, err = rest.ListProfiles()
+			Expect(err).To(MatchError("profiler server: 401 missing or invalid token"))
+		})
+
+		It("should reject the names escaping the profiles folder", func() {
+			for path, status := range map[string]int{
+				"/v1/profiles/..%2F.profiler_cfg": http.StatusNotFound,
+				"/v1/profiles/..":                 http.StatusBadRequest,
+				"/v1/profiles/.dev.yml":           http.StatusBadRequest,
+			} {
+				request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+				Expect(err).To(BeNil())
+				request.Header.Set("Authorization", "Bearer admin-token")
+
+				resp, err := server.Client().Do(request)
+				Expect(err).To(BeNil())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(status), path)
+			}
+		})
+
+		It("should verify the server certificate", func() {
+			viper.Set("httpSkipVerify", false)
+
+			_, err := rest.ListProfiles()
+			Expect(err).To(MatchError(ContainSubstring("certificate")))
+		})
+	})
+
+	Context("profiler agent", func() {
+		var folder string
+		var server *agent.Server
+		var listener net.Listener
+
+		startAgent := func(ttl time.Duration) {
+			var err error
+			server = agent.NewServer(ttl)
+			listener, err = agent.Listen(agent.SocketPath())
+			Expect(err).To(BeNil())
+			go server.Serve(listener)
+		}
+
+		BeforeEach(func() {
+			log.SetOutput(ioutil.Discard)
+
+			var err error
+			// Kept short, the path of a Unix socket being limited:
+			folder, err = ioutil.TempDir("", "agent")
+			Expect(err).To(BeNil())
+			viper.Set("agentSocket", folder+"/run/agent.sock")
+			startAgent(time.Minute)
+		})
+
+		AfterEach(func() {
+			listener.Close()
+			os.RemoveAll(folder)
+			log.SetOutput(os.Stderr)
+			viper.Set("agentSocket", "")
+		})
+
+		It("should hand the profiles it holds", func() {
+			_, ok := agent.Get("ssm/dev")
+			Expect(ok).To(BeFalse())
+
+			agent.Put("ssm/dev", map[string]string{"profile_name": "dev", "FOO": "bar"})
+			vars, ok := agent.Get("ssm/dev")
+			Expect(ok).To(BeTrue())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+			status, err := agent.GetStatus()
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(agent.Status{Profiles: 1}))
+
+			info, err := os.Stat(agent.SocketPath())
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			info, err = os.Stat(folder + "/run")
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		})
+
+		It("should forget a profile and all its versions", func() {
+			for _, key := range []string{"vault/dev@0", "vault/dev@3", "vault/devel@0", "ssm/dev"} {
+				agent.Put(key, map[string]string{"profile_name": "dev"})
+			}
+
+			agent.Forget("vault/dev")
+			for key, held := range map[string]bool{"vault/dev@0": false, "vault/dev@3": false, "vault/devel@0": true, "ssm/dev": true} {
+				_, ok := agent.Get(key)
+				Expect(ok).To(Equal(held), key)
+			}
+
+			Expect(agent.Flush()).To(Succeed())
+			status, err := agent.GetStatus()
+			Expect(err).To(BeNil())
+			Expect(status.Profiles).To(Equal(0))
+		})
+
+		It("should drop the profiles at the end of their TTL", func() {
+			listener.Close()
+			startAgent(50 * time.Millisecond)
+
+			agent.Put("ssm/dev", map[string]string{"profile_name": "dev"})
+			_, ok := agent.Get("ssm/dev")
+			Expect(ok).To(BeTrue())
+
+			Eventually(func() bool {
+				_, ok := agent.Get("ssm/dev")
+				return ok
+			}).Should(BeFalse())
+		})
+
+		It("should drop the profiles and not hold new ones while locked", func() {
+			agent.Put("ssm/dev", map[string]string{"profile_name": "dev"})
+
+			Expect(agent.Lock("")).To(MatchError("a passphrase is required to lock the agent"))
+			Expect(agent.Lock("s3cr3t")).To(Succeed())
+			_, ok := agent.Get("ssm/dev")
+			Expect(ok).To(BeFalse())
+			agent.Put("ssm/prod", map[string]string{"profile_name": "prod"})
+
+			status, err := agent.GetStatus()
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(agent.Status{Locked: true, Profiles: 0}))
+
+			Expect(agent.Unlock("wrong")).To(MatchError("invalid passphrase"))
+			Expect(agent.Unlock("s3cr3t")).To(Succeed())
+			Expect(agent.Unlock("s3cr3t")).To(MatchError("the agent is not locked"))
+
+			_, ok = agent.Get("ssm/dev")
+			Expect(ok).To(BeFalse())
+			_, ok = agent.Get("ssm/prod")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should only answer to its user", func() {
+			agent.Put("ssm/dev", map[string]string{"profile_name": "dev"})
+			server.UID = os.Getuid() + 1
+
+			_, ok := agent.Get("ssm/dev")
+			Expect(ok).To(BeFalse())
+			_, err := agent.GetStatus()
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should not start twice on the same socket", func() {
+			_, err := agent.Listen(agent.SocketPath())
+			Expect(err).To(MatchError(ContainSubstring("an agent is already listening")))
+
+			// The socket left by a stopped agent is replaced:
+			listener.Close()
+			Expect(ioutil.WriteFile(agent.SocketPath(), nil, 0600)).To(Succeed())
+			startAgent(time.Minute)
+			status, err := agent.GetStatus()
+			Expect(err).To(BeNil())
+			Expect(status.Locked).To(BeFalse())
+		})
+	})
+
+	Context("etcd backend", func() {
+		var endpoint string
+
+		BeforeEach(func() {
+			endpoint = startEtcd()
+			viper.Set("etcdEndpoints", []string{endpoint})
+			Expect(etcd.AddKVPair("dev", []string{"FOO", "bar"})).To(Succeed())
+			Expect(etcd.AddKVPair("prod", []string{})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(etcd.DeleteProfile("dev")).To(Succeed())
+			Expect(etcd.DeleteProfile("prod")).To(Succeed())
+			for _, key := range []string{"etcdEndpoints", "etcdPrefix", "etcdUsername", "etcdPassword"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should list the profiles", func() {
+			profiles, err := etcd.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+		})
+
+		It("should get the profile variables", func() {
+			vars, err := etcd.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+			exist, err := etcd.ProfileExist("missing")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+
+			_, err = etcd.GetProfile("missing")
+			Expect(err).To(MatchError("profile missing not found"))
+		})
+
+		It("should add and remove variables", func() {
+			Expect(etcd.AddKVPair("dev", []string{"A", "1", "FOO", "baz", "A", "2"})).To(Succeed())
+			Expect(etcd.RemoveKVPair("prod", "profile_name")).To(Succeed())
+			Expect(etcd.RemoveKVPair("prod", "profile_name")).To(Not(Succeed()))
+			Expect(etcd.AddKVPair("dev", []string{"A/B", "1"})).To(Not(Succeed()))
+
+			vars, err := etcd.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "baz", "A": "2"}))
+
+			profiles, err := etcd.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev"}))
+		})
+
+		It("should use the configured prefix", func() {
+			viper.Set("etcdPrefix", "/teams/platform")
+			Expect(etcd.AddKVPair("staging", []string{"A", "1"})).To(Succeed())
+
+			profiles, err := etcd.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"staging"}))
+
+			Expect(etcd.DeleteProfile("staging")).To(Succeed())
+			viper.Set("etcdPrefix", "")
+		})
+
+		It("should keep the metadata in a hidden variable", func() {
+			r, ok := backend.Get(backend.Etcd)
+			Expect(ok).To(BeTrue())
+
+			m, err := r.GetMeta("dev")
+			Expect(err).To(BeNil())
+			Expect(m.IsEmpty()).To(BeTrue())
+
+			m.Description = "the dev profile"
+			m.AddTags([]string{"team-a", "aws"})
+			m.SetKeyDescription("FOO", "the foo")
+			Expect(r.SetMeta("dev", m)).To(Succeed())
+
+			m, err = r.GetMeta("dev")
+			Expect(err).To(BeNil())
+			Expect(m.Description).To(Equal("the dev profile"))
+			Expect(m.HasTags([]string{"aws", "team-a"})).To(BeTrue())
+			Expect(m.Keys).To(Equal(map[string]string{"FOO": "the foo"}))
+
+			keys, err := r.ShowProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"FOO", "profile_name"}))
+
+			vars, err := r.GetProfile("dev")
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+		})
+
+		It("should authenticate with username and password", func() {
+			client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, Logger: zap.NewNop()})
+			Expect(err).To(BeNil())
+			defer client.Close()
+
+			ctx := context.Background()
+			_, err = client.UserAdd(ctx, "root", "secret")
+			Expect(err).To(BeNil())
+			_, err = client.UserGrantRole(ctx, "root", "root")
+			Expect(err).To(BeNil())
+			_, err = client.AuthEnable(ctx)
+			Expect(err).To(BeNil())
+
+			_, err = etcd.ListProfiles()
+			Expect(err).To(Not(BeNil()))
+
+			viper.Set("etcdUsername", "root")
+			viper.Set("etcdPassword", "secret")
+			profiles, err := etcd.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "prod"}))
+
+			// Restore the anonymous access for the other tests:
+			root, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, Username: "root", Password: "secret", Logger: zap.NewNop()})
+			Expect(err).To(BeNil())
+			defer root.Close()
+			_, err = root.AuthDisable(ctx)
+			Expect(err).To(BeNil())
+			_, err = root.UserDelete(ctx, "root")
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("completion cache", func() {
+		var folder string
+		var cacheHome string
+		var calls int
+
+		list := func() ([]string, error) {
+			calls++
+			return []string{"dev", "prod"}, nil
+		}
+
+		BeforeEach(func() {
+			var err error
+			folder, err = ioutil.TempDir("", "profiler-completion")
+			Expect(err).To(BeNil())
+
+			// os.UserCacheDir follows XDG_CACHE_HOME:
+			cacheHome = os.Getenv("XDG_CACHE_HOME")
+			os.Setenv("XDG_CACHE_HOME", folder)
+			calls = 0
+		})
+
+		AfterEach(func() {
+			os.Setenv("XDG_CACHE_HOME", cacheHome)
+			os.RemoveAll(folder)
+			viper.Set("completionCacheTTL", "30s")
+		})
+
+		It("should serve the cached values until they expire", func() {
+			viper.Set("completionCacheTTL", "200ms")
+
+			for i := 0; i < 2; i++ {
+				values, err := completion.Cached("ssm-profiles", "us-east-1", list)
+				Expect(err).To(BeNil())
+				Expect(values).To(Equal([]string{"dev", "prod"}))
+			}
+			Expect(calls).To(Equal(1))
+
+			time.Sleep(250 * time.Millisecond)
+			_, err := completion.Cached("ssm-profiles", "us-east-1", list)
+			Expect(err).To(BeNil())
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should not cache the values when the TTL is 0", func() {
+			viper.Set("completionCacheTTL", "0")
+
+			for i := 0; i < 2; i++ {
+				_, err := completion.Cached("ssm-profiles", "us-east-1", list)
+				Expect(err).To(BeNil())
+			}
+			Expect(calls).To(Equal(2))
+			Expect(folder + "/profiler").To(Not(BeAnExistingFile()))
+		})
+
+		It("should not serve the values of another source", func() {
+			viper.Set("completionCacheTTL", "1h")
+
+			_, err := completion.Cached("ssm-profiles", "us-east-1", list)
+			Expect(err).To(BeNil())
+			_, err = completion.Cached("ssm-profiles", "eu-west-1", list)
+			Expect(err).To(BeNil())
+			Expect(calls).To(Equal(2))
+
+			_, err = completion.Cached("ssm-profiles", "us-east-1", list)
+			Expect(err).To(BeNil())
+			Expect(calls).To(Equal(2))
+		})
+	})
+
+	Context("offline cache", func() {
+		var folder string
+		var fake *ssmfake.SSM
+		var warnings *bytes.Buffer
+
+		unreachable := awserr.New(request.ErrCodeRequestError, "send request failed", &url.Error{
+			Op:  "Post",
+			URL: "https://ssm.us-east-1.amazonaws.com/",
+			Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")},
+		})
+
+		BeforeEach(func() {
+			var err error
+			folder, err = ioutil.TempDir("", "profiler-cache")
+			Expect(err).To(BeNil())
+			viper.Set("offlineCacheFolder", folder+"/offline")
+			viper.Set("offlineCacheTTL", "1h")
+
+			warnings = &bytes.Buffer{}
+			cache.SetWarningOutput(warnings)
+
+			fake = ssmfake.New()
+			ssm.SetService(fake)
+			for name, value := range map[string]string{"profile_name": "dev", "DB_PASSWORD": "s3cr3t"} {
+				_, err := fake.PutParameter(&awsssm.PutParameterInput{
+					Name:  aws.String("/profiler/dev/" + name),
+					Value: aws.String(value),
+					Type:  aws.String(awsssm.ParameterTypeString),
+				})
+				Expect(err).To(BeNil())
+			}
+		})
+
+		AfterEach(func() {
+			ssm.SetService(nil)
+			cache.SetWarningOutput(os.Stderr)
+			os.RemoveAll(folder)
+			for _, key := range []string{"offlineCacheFolder", "offlineCacheTTL", "offlineCacheKeyFile", "offline"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should keep an encrypted copy of the fetched profiles", func() {
+			vars, stale, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+			Expect(stale).To(BeFalse())
+			Expect(vars).To(HaveKeyWithValue("DB_PASSWORD", "s3cr3t"))
+
+			entries, err := cache.List()
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Name()).To(Equal("ssm/dev"))
+			Expect(entries[0].Source).To(Equal("us-east-1"))
+			Expect(entries[0].Expires.Sub(entries[0].Fetched)).To(Equal(time.Hour))
+
+			info, err := os.Stat(folder + "/offline")
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+
+			files, err := ioutil.ReadDir(folder + "/offline")
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(2)) // the entry and the generated key
+			for _, f := range files {
+				Expect(f.Mode().Perm()).To(Equal(os.FileMode(0600)), f.Name())
+				Expect(f.Name()).NotTo(ContainSubstring("dev"))
+
+				b, err := ioutil.ReadFile(folder + "/offline/" + f.Name())
+				Expect(err).To(BeNil())
+				Expect(string(b)).NotTo(ContainSubstring("s3cr3t"))
+			}
+		})
+
+		It("should fall back to the cache when the backend is unreachable", func() {
+			_, _, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+
+			fake.FailNext("GetParametersByPath", unreachable)
+			vars, stale, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+			Expect(stale).To(BeTrue())
+			Expect(vars).To(HaveKeyWithValue("DB_PASSWORD", "s3cr3t"))
+			Expect(warnings.String()).To(ContainSubstring("ssm unreachable"))
+			Expect(warnings.String()).To(ContainSubstring("using the ssm/dev profile cached from us-east-1, stale since "))
+
+			// Only the network failures fall back to the cache:
+			fake.FailNext("GetParametersByPath", awserr.New("AccessDeniedException", "denied", nil))
+			_, _, err = cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(MatchError(ContainSubstring("AccessDeniedException")))
+
+			// Nor without cached copy:
+			fake.FailNext("GetParametersByPath", unreachable)
+			_, _, err = cache.Fetch("ssm", "prod", "us-east-1", ssm.GetProfile)
+			Expect(err).To(MatchError(ContainSubstring("send request failed")))
+		})
+
+		It("should only read the cache when offline", func() {
+			_, _, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+			calls := fake.Calls["GetParametersByPath"]
+
+			viper.Set("offline", true)
+			vars, stale, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+			Expect(stale).To(BeTrue())
+			Expect(vars).To(HaveKeyWithValue("profile_name", "dev"))
+			Expect(fake.Calls["GetParametersByPath"]).To(Equal(calls))
+			Expect(warnings.String()).To(ContainSubstring("offline, using the ssm/dev profile"))
+
+			_, _, err = cache.Fetch("consul", "dev", "", consul.GetProfile)
+			Expect(err).To(MatchError("offline: the consul/dev profile is not cached"))
+		})
+
+		It("should not use the profiles cached from another source", func() {
+			_, _, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+
+			viper.Set("offline", true)
+			_, _, err = cache.Fetch("ssm", "dev", "eu-west-1", ssm.GetProfile)
+			Expect(err).To(MatchError("offline: the ssm/dev profile is cached from us-east-1, not eu-west-1"))
+
+			_, _, err = cache.Load("ssm", "dev", "eu-west-1")
+			Expect(err).To(MatchError(cache.ErrOtherSource))
+		})
+
+		It("should not use the expired profiles", func() {
+			viper.Set("offlineCacheTTL", "1ms")
+			_, _, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+			time.Sleep(10 * time.Millisecond)
+
+			viper.Set("offline", true)
+			_, _, err = cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(MatchError(ContainSubstring("offline: the cached ssm/dev profile expired on ")))
+
+			entries, err := cache.List()
+			Expect(err).To(BeNil())
+			Expect(entries[0].Expired()).To(BeTrue())
+		})
+
+		It("should not decrypt the profiles with another key", func() {
+			_, _, err := cache.Fetch("ssm", "dev", "us-east-1", ssm.GetProfile)
+			Expect(err).To(BeNil())
+
+			Expect(ioutil.WriteFile(folder+"/key", []byte("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="), 0600)).To(Succeed())
+			viper.Set("offlineCacheKeyFile", folder+"/key")
+			_, _, err = cache.Load("ssm", "dev", "us-east-1")
+			Expect(err).To(MatchError(ContainSubstring("can't decrypt the cached profile")))
+		})
+
+		It("should remove the cached profiles", func() {
+			for _, name := range []string{"dev", "prod"} {
+				Expect(cache.Store("ssm", name, "us-east-1", map[string]string{"profile_name": name})).To(Succeed())
+			}
+
+			Expect(cache.Remove("ssm", "dev")).To(Succeed())
+			Expect(cache.Remove("ssm", "dev")).To(MatchError("ssm/dev is not cached"))
+			entries, err := cache.List()
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Name()).To(Equal("ssm/prod"))
+
+			Expect(cache.Clear()).To(Succeed())
+			entries, err = cache.List()
+			Expect(err).To(BeNil())
+			Expect(entries).To(BeEmpty())
+
+			backend, profileName, err := cache.ParseName("consul/team/dev")
+			Expect(err).To(BeNil())
+			Expect([]string{backend, profileName}).To(Equal([]string{"consul", "team/dev"}))
+			_, _, err = cache.ParseName("dev")
+			Expect(err).To(MatchError("invalid cached profile dev, expecting <backend>/<profile>"))
+		})
+	})
+
+	Context("Vault backend", func() {
+		var server *vaultfake.Server
+
+		BeforeEach(func() {
+			server = vaultfake.New("s.profiler")
+			server.Put("secret", "profiler/dev", map[string]string{"profile_name": "dev", "FOO": "bar"})
+			server.Put("secret", "profiler/team/prod", map[string]string{"profile_name": "team/prod"})
+			viper.Set("vaultAddress", server.URL)
+			viper.Set("vaultToken", "s.profiler")
+		})
+
+		AfterEach(func() {
+			server.Close()
+			for _, key := range []string{"vaultAddress", "vaultToken", "vaultRoleId", "vaultSecretId", "vaultNamespace", "vaultMount", "vaultPath"} {
+				viper.Set(key, "")
+			}
+		})
+
+		It("should list the profiles of every folder", func() {
+			profiles, err := vault.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev", "team/prod"}))
+		})
+
+		It("should be configured with the VAULT_ADDR environment variable", func() {
+			viper.Set("vaultAddress", "")
+			Expect(vault.Configured()).To(BeFalse())
+
+			os.Setenv("VAULT_ADDR", server.URL)
+			defer os.Unsetenv("VAULT_ADDR")
+			Expect(vault.Configured()).To(BeTrue())
+		})
+
+		It("should reject the profile names leaving the profiles path", func() {
+			for _, name := range []string{"../x", "team/../../x", "dev?x", "/dev", "dev/"} {
+				_, err := vault.GetProfile(name, 0)
+				Expect(err).To(MatchError("invalid profile name " + name))
+				Expect(vault.AddKVPair(name, []string{"A", "1"})).To(MatchError("invalid profile name " + name))
+				Expect(vault.DeleteProfile(name)).To(MatchError("invalid profile name " + name))
+			}
+
+			_, err := vault.GetProfile("team/prod", 0)
+			Expect(err).To(BeNil())
+		})
+
+		It("should get the profile variables", func() {
+			vars, err := vault.GetProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "FOO": "bar"}))
+
+			_, err = vault.GetProfile("missing", 0)
+			Expect(err).To(MatchError("profile missing not found"))
+		})
+
+		It("should add and remove variables", func() {
+			Expect(vault.AddKVPair("dev", []string{"A", "1", "B", "2"})).To(Succeed())
+			Expect(vault.RemoveKVPair("dev", "FOO")).To(Succeed())
+			Expect(vault.RemoveKVPair("dev", "FOO")).To(Not(Succeed()))
+
+			keys, err := vault.ShowProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal([]string{"A", "B", "profile_name"}))
+		})
+
+		It("should create and delete a profile", func() {
+			Expect(vault.AddKVPair("new", []string{})).To(Succeed())
+
+			exist, err := vault.ProfileExist("new")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeTrue())
+
+			Expect(vault.DeleteProfile("new")).To(Succeed())
+
+			exist, err = vault.ProfileExist("new")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+		})
+
+		It("should read and restore the previous versions", func() {
+			Expect(vault.AddKVPair("dev", []string{"FOO", "baz"})).To(Succeed())
+
+			versions, err := vault.History("dev")
+			Expect(err).To(BeNil())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[1].Version).To(Equal(2))
+
+			vars, err := vault.GetProfile("dev", 1)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+
+			Expect(vault.Rollback("dev", 1)).To(Succeed())
+			vars, err = vault.GetProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+
+			_, err = vault.GetProfile("dev", 9)
+			Expect(err).To(MatchError("version 9 of the dev profile not found"))
+		})
+
+		It("should read the values written by other tools as strings", func() {
+			server.PutData("secret", "profiler/app", map[string]interface{}{
+				"PORT":    8080,
+				"RATIO":   0.5,
+				"DEBUG":   true,
+				"EMPTY":   nil,
+				"HOSTS":   []string{"a", "b"},
+				"SETTING": map[string]interface{}{"level": "info"},
+				"NAME":    "app",
+			})
+
+			vars, err := vault.GetProfile("app", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{
+				"PORT":    "8080",
+				"RATIO":   "0.5",
+				"DEBUG":   "true",
+				"EMPTY":   "",
+				"HOSTS":   `["a","b"]`,
+				"SETTING": `{"level":"info"}`,
+				"NAME":    "app",
+			}))
+
+			Expect(vault.AddKVPair("app", []string{"FOO", "bar"})).To(Succeed())
+			vars, err = vault.GetProfile("app", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("PORT", "8080"))
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+		})
+
+		It("should write over a deleted latest version", func() {
+			Expect(vault.AddKVPair("dev", []string{"FOO", "baz"})).To(Succeed())
+			server.Delete("secret", "profiler/dev")
+
+			exist, err := vault.ProfileExist("dev")
+			Expect(err).To(BeNil())
+			Expect(exist).To(BeFalse())
+
+			// The check-and-set is done on the deleted version 2:
+			Expect(vault.AddKVPair("dev", []string{"A", "1"})).To(Succeed())
+			vars, err := vault.GetProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"profile_name": "dev", "A": "1"}))
+
+			versions, err := vault.History("dev")
+			Expect(err).To(BeNil())
+			Expect(versions).To(HaveLen(3))
+			Expect(versions[1].Deleted).To(BeTrue())
+
+			// Restoring the deleted version is refused, the older ones aren't:
+			Expect(vault.Rollback("dev", 2)).To(MatchError("version 2 of the dev profile not found"))
+			Expect(vault.Rollback("dev", 1)).To(Succeed())
+			vars, err = vault.GetProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("FOO", "bar"))
+		})
+
+		It("should use the configured mount and path", func() {
+			server.Put("kv", "teams/dev", map[string]string{"profile_name": "dev", "TEAM": "platform"})
+			viper.Set("vaultMount", "kv")
+			viper.Set("vaultPath", "/teams/")
+
+			profiles, err := vault.ListProfiles()
+			Expect(err).To(BeNil())
+			Expect(profiles).To(Equal([]string{"dev"}))
+
+			vars, err := vault.GetProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(vars).To(HaveKeyWithValue("TEAM", "platform"))
+		})
+
+		It("should log in with AppRole once", func() {
+			server.RoleID = "role"
+			server.SecretID = "secret"
+			viper.Set("vaultToken", "")
+			viper.Set("vaultRoleId", "role")
+			viper.Set("vaultSecretId", "secret")
+
+			_, err := vault.ListProfiles()
+			Expect(err).To(BeNil())
+			_, err = vault.GetProfile("dev", 0)
+			Expect(err).To(BeNil())
+			Expect(server.Logins).To(Equal(1))
+		})
+
+		It("should send the namespace", func() {
+			server.Namespace = "team"
+
+			_, err := vault.ListProfiles()
+			Expect(err).To(MatchError("vault: 403 permission denied"))
+
+			viper.Set("vaultNamespace", "team")
+			_, err = vault.ListProfiles()
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("Alternate config", func() {
+		// Simulate a user setings his custom configFile path:
+		os.Setenv("PROFILER_CFG", altConfigFile)
+
+		It("should be type of []string", func() {
+			Expect(reflect.TypeOf(altFiles).String()).To(Equal("[]string"))
+		})
+
+		It("should contain 3 elements", func() {
+			Expect(altFiles).To(HaveLen(3))
+		})
+
+		It("should contain .env.yml, .testrc & .test.yml", func() {
+			Expect(altFiles).To(ContainElement(altProfilesPath + ".test.yml"))
+		})
+	})
+
+	Context("setConfigFile", func() {
+		// Simulate a user setings his custom configFile path:
+		os.Setenv("PROFILER_CFG", noCfgFilePath)
+
+		cmd.InitConfig()
+
+		It("should have created a config file", func() {
+			Expect(noCfgFilePath).To(BeAnExistingFile())
+		})
+
+		It("should set the SSM defaults", func() {
+			os.Setenv("PROFILER_CFG", noCfgFilePath)
+			for _, key := range []string{"ssmPageSize", "ssmConcurrency", "ssmMaxRetries"} {
+				viper.Set(key, nil)
+			}
+
+			cmd.InitConfig()
+
+			Expect(viper.GetInt("ssmPageSize")).To(Equal(10))
+			Expect(viper.GetInt("ssmConcurrency")).To(Equal(5))
+			Expect(viper.GetInt("ssmMaxRetries")).To(Equal(5))
+		})
+	})
+
+	AfterSuite(func() {
+		// Cleanup:
+		os.Remove(configFile)
+		os.Remove(noCfgFilePath)
+		os.RemoveAll(profilesPath)
+		if etcdServer != nil {
+			etcdServer.Close()
+			os.RemoveAll(etcdServer.Config().Dir)
+		}
+		if gnupgHome != "" {
+			exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+			os.RemoveAll(gnupgHome)
+		}
 	})
 })
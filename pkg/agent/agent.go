@@ -0,0 +1,61 @@
+// Package agent implement `profiler agent`, a per-user daemon keeping the
+// fetched or decrypted remote profiles in memory for a while, so the next uses
+// of a profile don't have to contact its backend again.
+//
+// The CLI and the agent talk over a Unix socket, one JSON request and one JSON
+// response per connection.
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	opGet    = "get"
+	opPut    = "put"
+	opForget = "forget"
+	opFlush  = "flush"
+	opLock   = "lock"
+	opUnlock = "unlock"
+	opStatus = "status"
+)
+
+// request is sent by the client for every operation
+type request struct {
+	Op         string            `json:"op"`
+	Key        string            `json:"key,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	Passphrase string            `json:"passphrase,omitempty"`
+}
+
+// response is the answer of the agent to a request
+type response struct {
+	Found     bool              `json:"found,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Locked    bool              `json:"locked,omitempty"`
+	Profiles  int               `json:"profiles,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+/*Status is the state of a running agent*/
+type Status struct {
+	Locked   bool
+	Profiles int
+}
+
+/*SocketPath return the socket of the agent: `agentSocket` when configured, otherwise profiler/agent.sock in $XDG_RUNTIME_DIR or in a per-user folder of the temporary directory*/
+func SocketPath() string {
+	if socket := viper.GetString("agentSocket"); socket != "" {
+		return socket
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "profiler", "agent.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("profiler-%d", os.Getuid()), "agent.sock")
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+// dialTimeout keep the CLI responsive when the agent doesn't answer
+const dialTimeout = time.Second
+
+// call send the request to the agent listening on SocketPath and return its
+// response
+func call(req request) (response, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(), dialTimeout)
+	if err != nil {
+		return response{}, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return response{}, err
+	}
+
+	var resp response
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return response{}, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+
+	return resp, nil
+}
+
+/*Get return the variables of the given profile when the agent holds them, a missing, locked or stopped agent being a cache miss*/
+func Get(key string) (map[string]string, bool) {
+	resp, err := call(request{Op: opGet, Key: key})
+	if err != nil || !resp.Found {
+		return nil, false
+	}
+
+	return resp.Variables, true
+}
+
+/*Put hand the variables of the given profile to the agent, if one is running*/
+func Put(key string, vars map[string]string) {
+	call(request{Op: opPut, Key: key, Variables: vars})
+}
+
+/*Forget drop the given profile, and all its versions, from the agent*/
+func Forget(key string) {
+	call(request{Op: opForget, Key: key})
+}
+
+/*Flush drop every profile held by the agent*/
+func Flush() error {
+	_, err := call(request{Op: opFlush})
+	return err
+}
+
+/*Lock make the agent drop its profiles and refuse to hold new ones until unlocked with the same passphrase*/
+func Lock(passphrase string) error {
+	_, err := call(request{Op: opLock, Passphrase: passphrase})
+	return err
+}
+
+/*Unlock make a locked agent hold the profiles again*/
+func Unlock(passphrase string) error {
+	_, err := call(request{Op: opUnlock, Passphrase: passphrase})
+	return err
+}
+
+/*GetStatus return the state of the running agent*/
+func GetStatus() (Status, error) {
+	resp, err := call(request{Op: opStatus})
+	if err != nil {
+		return Status{}, err
+	}
+
+	return Status{Locked: resp.Locked, Profiles: resp.Profiles}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID can't check the peer of the socket on this platform, the agent
+// then refuses every connection rather than trusting the socket permissions
+func peerUID(conn *net.UnixConn) (int, error) {
+	return -1, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTimeout bound the time a client can keep a connection open
+const requestTimeout = 5 * time.Second
+
+// entry is a cached profile
+type entry struct {
+	variables map[string]string
+	expires   time.Time
+}
+
+/*Server is the agent, caching the profiles handed by the CLI for TTL*/
+type Server struct {
+	TTL time.Duration
+	// UID is the only user allowed to talk to the agent
+	UID int
+
+	mutex   sync.Mutex
+	entries map[string]entry
+	locked  bool
+	salt    []byte
+	hash    []byte
+}
+
+/*NewServer return an agent caching the profiles for the given duration and only answering to the current user*/
+func NewServer(ttl time.Duration) *Server {
+	return &Server{
+		TTL:     ttl,
+		UID:     os.Getuid(),
+		entries: make(map[string]entry),
+	}
+}
+
+/*Listen create the socket of the agent, in a folder only readable by the current user, replacing the socket of an agent which is no longer running*/
+func Listen(socket string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(socket), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		conn, err := net.DialTimeout("unix", socket, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", socket)
+		}
+		// Stale socket of a stopped agent:
+		os.Remove(socket)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+/*Serve answer the requests of the clients until the listener is closed*/
+func (s *Server) Serve(listener net.Listener) error {
+	ticker := time.NewTicker(time.Minute)
+	done := make(chan struct{})
+	defer func() {
+		ticker.Stop()
+		close(done)
+	}()
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.expire()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go s.handle(conn.(*net.UnixConn))
+	}
+}
+
+func (s *Server) handle(conn *net.UnixConn) {
+	defer conn.Close()
+
+	// The profiles are only handed to the user running the agent:
+	uid, err := peerUID(conn)
+	if err != nil || uid != s.UID {
+		log.Printf("Connection refused: peer uid %d (%v)", uid, err)
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req request
+	err = json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		json.NewEncoder(conn).Encode(response{Error: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+
+	json.NewEncoder(conn).Encode(s.process(req))
+}
+
+func (s *Server) process(req request) response {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.locked && req.Op != opUnlock && req.Op != opStatus && req.Op != opFlush {
+		return response{Locked: true, Error: "the agent is locked"}
+	}
+
+	switch req.Op {
+	case opGet:
+		e, ok := s.entries[req.Key]
+		if !ok || time.Now().After(e.expires) {
+			delete(s.entries, req.Key)
+			return response{}
+		}
+		return response{Found: true, Variables: e.variables}
+	case opPut:
+		if s.entries == nil {
+			s.entries = make(map[string]entry)
+		}
+		s.entries[req.Key] = entry{
+			variables: req.Variables,
+			expires:   time.Now().Add(s.TTL),
+		}
+		return response{}
+	case opForget:
+		for k := range s.entries {
+			// The versions of a profile are cached as <key>@<version>:
+			if k == req.Key || strings.HasPrefix(k, req.Key+"@") {
+				delete(s.entries, k)
+			}
+		}
+		return response{}
+	case opFlush:
+		s.entries = make(map[string]entry)
+		return response{Locked: s.locked}
+	case opLock:
+		return s.lock(req.Passphrase)
+	case opUnlock:
+		return s.unlock(req.Passphrase)
+	case opStatus:
+		return response{Locked: s.locked, Profiles: len(s.entries)}
+	}
+
+	return response{Error: fmt.Sprintf("unknown operation %s", req.Op)}
+}
+
+func (s *Server) lock(passphrase string) response {
+	if passphrase == "" {
+		return response{Error: "a passphrase is required to lock the agent"}
+	}
+
+	s.salt = make([]byte, 16)
+	_, err := rand.Read(s.salt)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+	s.hash = hashPassphrase(s.salt, passphrase)
+	s.locked = true
+	// The profiles are dropped rather than kept in memory while locked:
+	s.entries = make(map[string]entry)
+
+	return response{Locked: true}
+}
+
+func (s *Server) unlock(passphrase string) response {
+	if !s.locked {
+		return response{Error: "the agent is not locked"}
+	}
+
+	if subtle.ConstantTimeCompare(hashPassphrase(s.salt, passphrase), s.hash) != 1 {
+		return response{Locked: true, Error: "invalid passphrase"}
+	}
+	s.locked = false
+	s.salt = nil
+	s.hash = nil
+
+	return response{}
+}
+
+func hashPassphrase(salt []byte, passphrase string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), passphrase...))
+	return sum[:]
+}
+
+// expire drop the profiles whose TTL is over, to not keep them in memory
+// until their next use
+func (s *Server) expire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
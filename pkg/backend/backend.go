@@ -0,0 +1,175 @@
+// Package backend gather the profile stores behind a single interface and a
+// registry, from which the backend commands, the listing, the picker, the
+// shell completion and `use` are built.
+package backend
+
+import (
+	"github.com/julienlevasseur/profiler/pkg/meta"
+)
+
+/*Backend is a store of profiles*/
+type Backend interface {
+	ProfileExist(profileName string) (bool, error)
+	ListProfiles() ([]string, error)
+	ShowProfile(profileName string) ([]string, error)
+	GetProfile(profileName string) (map[string]string, error)
+	AddKVPair(profileName string, KVs []string) error
+	RemoveKVPair(profileName string, key string) error
+	DeleteProfile(profileName string) error
+}
+
+/*Registration is a registered backend with what the commands need to know about it*/
+type Registration struct {
+	Backend
+
+	// Name of the backend, which is also the name of its command
+	Name string
+	// Title of the backend in the messages
+	Title string
+	// Local tell if the profiles are stored locally rather than remotely
+	Local bool
+	// Offline tell if the profiles are kept in the offline cache
+	Offline bool
+
+	// Configured tell if the backend is configured, its profiles being then
+	// listed
+	Configured func() bool
+	// Secret tell if the profile holds secrets, which are never written on
+	// disk (nil for never)
+	Secret func(profileName string) bool
+	// Source describe where the profiles are read from (address, region,
+	// namespace...), keying the profiles kept by the caches
+	Source func() string
+	// Version return the version of the profiles read, for the versioned
+	// backends (nil otherwise)
+	Version func() string
+	// GetMeta return the metadata of a profile (nil for the backends without
+	// metadata)
+	GetMeta func(profileName string) (meta.Meta, error)
+	// SetMeta write the metadata of a profile (nil for the backends whose
+	// metadata can't be set by profiler)
+	SetMeta func(profileName string, m meta.Meta) error
+}
+
+/*IsSecret tell if the given profile of the backend holds secrets*/
+func (r Registration) IsSecret(profileName string) bool {
+	return r.Secret != nil && r.Secret(profileName)
+}
+
+// registry hold the registered backends, in their registration order
+var registry []Registration
+
+/*Register add a backend to the registry*/
+func Register(r Registration) {
+	registry = append(registry, r)
+}
+
+/*All return the registered backends, in their registration order*/
+func All() []Registration {
+	return registry
+}
+
+/*Get return the registered backend of the given name*/
+func Get(name string) (Registration, bool) {
+	for _, r := range registry {
+		if r.Name == name {
+			return r, true
+		}
+	}
+
+	return Registration{}, false
+}
+
+// withMeta keep the metadata of the profiles of the backend, which has no
+// place for it, in their meta.Key variable. This variable is hidden from the
+// variables of the profiles.
+func withMeta(r Registration) Registration {
+	b := r.Backend
+
+	r.Backend = hiddenMeta{b}
+	r.GetMeta = func(profileName string) (meta.Meta, error) {
+		vars, err := b.GetProfile(profileName)
+		if err != nil {
+			return meta.Meta{}, err
+		}
+
+		return meta.Decode(vars[meta.Key])
+	}
+	r.SetMeta = func(profileName string, m meta.Meta) error {
+		value, err := meta.Encode(m)
+		if err != nil {
+			return err
+		}
+
+		return b.AddKVPair(profileName, []string{meta.Key, value})
+	}
+
+	return r
+}
+
+// hiddenMeta hide the meta.Key variable from the profiles of a backend
+type hiddenMeta struct {
+	Backend
+}
+
+func (h hiddenMeta) ShowProfile(profileName string) ([]string, error) {
+	keys, err := h.Backend.ShowProfile(profileName)
+	if err != nil {
+		return keys, err
+	}
+
+	var visible []string
+	for _, k := range keys {
+		if k != meta.Key {
+			visible = append(visible, k)
+		}
+	}
+
+	return visible, nil
+}
+
+func (h hiddenMeta) GetProfile(profileName string) (map[string]string, error) {
+	vars, err := h.Backend.GetProfile(profileName)
+	delete(vars, meta.Key)
+
+	return vars, err
+}
+
+// funcs implement Backend with the functions of a backend package
+type funcs struct {
+	profileExist  func(profileName string) (bool, error)
+	listProfiles  func() ([]string, error)
+	showProfile   func(profileName string) ([]string, error)
+	getProfile    func(profileName string) (map[string]string, error)
+	addKVPair     func(profileName string, KVs []string) error
+	removeKVPair  func(profileName string, key string) error
+	deleteProfile func(profileName string) error
+}
+
+func (f funcs) ProfileExist(profileName string) (bool, error) {
+	return f.profileExist(profileName)
+}
+
+func (f funcs) ListProfiles() ([]string, error) {
+	return f.listProfiles()
+}
+
+func (f funcs) ShowProfile(profileName string) ([]string, error) {
+	return f.showProfile(profileName)
+}
+
+func (f funcs) GetProfile(profileName string) (map[string]string, error) {
+	return f.getProfile(profileName)
+}
+
+func (f funcs) AddKVPair(profileName string, KVs []string) error {
+	return f.addKVPair(profileName, KVs)
+}
+
+func (f funcs) RemoveKVPair(profileName string, key string) error {
+	return f.removeKVPair(profileName, key)
+}
+
+func (f funcs) DeleteProfile(profileName string) error {
+	return f.deleteProfile(profileName)
+}
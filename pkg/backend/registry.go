@@ -0,0 +1,298 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/julienlevasseur/profiler/pkg/consul"
+	"github.com/julienlevasseur/profiler/pkg/etcd"
+	"github.com/julienlevasseur/profiler/pkg/k8s"
+	"github.com/julienlevasseur/profiler/pkg/pass"
+	"github.com/julienlevasseur/profiler/pkg/rest"
+	"github.com/julienlevasseur/profiler/pkg/s3"
+	"github.com/julienlevasseur/profiler/pkg/secretsmanager"
+	"github.com/julienlevasseur/profiler/pkg/ssm"
+	"github.com/julienlevasseur/profiler/pkg/vault"
+)
+
+/*Names of the registered backends*/
+const (
+	SSM            = "ssm"
+	Consul         = "consul"
+	Vault          = "vault"
+	Etcd           = "etcd"
+	S3             = "s3"
+	SecretsManager = "secretsmanager"
+	Pass           = "pass"
+	K8s            = "k8s"
+	HTTP           = "http"
+)
+
+/*Options of the versioned backends, set by the flags of their commands*/
+var (
+	// VaultVersion is the version of the Vault profiles read, the current
+	// one when 0
+	VaultVersion int
+	// SecretsManagerStage is the version stage of the Secrets Manager
+	// profiles read
+	SecretsManagerStage = secretsmanager.StageCurrent
+	// SecretsManagerForce delete the Secrets Manager profiles without
+	// recovery window
+	SecretsManagerForce bool
+)
+
+func always(string) bool {
+	return true
+}
+
+// ssmConfigured tell if SSM profiles have to be listed. `ssmRegion` having a
+// default, SSM is only considered configured when the region is set in the
+// configuration file or another SSM option is set.
+func ssmConfigured() bool {
+	if viper.InConfig("ssmRegion") {
+		return true
+	}
+
+	for _, key := range []string{"ssmAwsProfile", "ssmRoleArn", "ssmEndpoint", "ssmPathPrefix", "ssmKmsKeyId"} {
+		if viper.GetString(key) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ssmSecret tell if the SSM profile holds SecureString parameters, which is
+// assumed when they can't be listed
+func ssmSecret(profileName string) bool {
+	keys, err := ssm.SecureKeys(profileName)
+
+	return err != nil || len(keys) > 0
+}
+
+// ssmAddKVPair add the variables to the SSM profile, creating its
+// `profile_name` parameter if it doesn't exist yet
+func ssmAddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	exist, err := ssm.ProfileExist(profileName)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]string)
+	if !exist {
+		vars["profile_name"] = profileName
+	}
+	for i := 0; i < len(KVs); i += 2 {
+		vars[KVs[i]] = KVs[i+1]
+	}
+
+	return ssm.AddParameters(profileName, vars, nil, false)
+}
+
+// k8sConfigured tell if Kubernetes profiles have to be listed, which is opt-in
+// as most kubeconfigs aren't meant to hold profiles
+func k8sConfigured() bool {
+	return viper.GetString("k8sNamespace") != "" ||
+		viper.GetString("k8sContext") != "" ||
+		viper.GetString("k8sKubeconfig") != ""
+}
+
+// secretsManagerConfigured tell if Secrets Manager profiles have to be listed,
+// which is opt-in as the region alone can't tell
+func secretsManagerConfigured() bool {
+	return viper.GetString("secretsManagerRegion") != "" ||
+		viper.GetString("secretsManagerPrefix") != "" ||
+		viper.GetString("secretsManagerEndpoint") != "" ||
+		viper.GetString("secretsManagerTag") != ""
+}
+
+func init() {
+	Register(Registration{
+		Name:    SSM,
+		Title:   "SSM",
+		Offline: true,
+		Backend: funcs{
+			profileExist:  ssm.ProfileExist,
+			listProfiles:  ssm.ListProfiles,
+			showProfile:   ssm.ShowProfile,
+			getProfile:    ssm.GetProfile,
+			addKVPair:     ssmAddKVPair,
+			removeKVPair:  ssm.RemoveParameter,
+			deleteProfile: ssm.RemoveProfile,
+		},
+		Configured: ssmConfigured,
+		Secret:     ssmSecret,
+		Source:     ssm.Source,
+		GetMeta:    ssm.GetMeta,
+		SetMeta:    ssm.SetMeta,
+	})
+
+	Register(Registration{
+		Name:    Consul,
+		Title:   "Consul",
+		Offline: true,
+		Backend: funcs{
+			profileExist:  consul.ProfileExist,
+			listProfiles:  consul.ListProfiles,
+			showProfile:   consul.ShowProfile,
+			getProfile:    consul.GetProfile,
+			addKVPair:     consul.AddKVPair,
+			removeKVPair:  consul.RemoveKVPair,
+			deleteProfile: consul.DeleteProfile,
+		},
+		Configured: consul.Configured,
+		Source:     consul.Source,
+		GetMeta:    consul.GetMeta,
+		SetMeta:    consul.SetMeta,
+	})
+
+	Register(withMeta(Registration{
+		Name:  Vault,
+		Title: "Vault",
+		Backend: funcs{
+			profileExist: vault.ProfileExist,
+			listProfiles: vault.ListProfiles,
+			showProfile: func(profileName string) ([]string, error) {
+				return vault.ShowProfile(profileName, VaultVersion)
+			},
+			getProfile: func(profileName string) (map[string]string, error) {
+				return vault.GetProfile(profileName, VaultVersion)
+			},
+			addKVPair:     vault.AddKVPair,
+			removeKVPair:  vault.RemoveKVPair,
+			deleteProfile: vault.DeleteProfile,
+		},
+		Configured: vault.Configured,
+		Source:     vault.Source,
+		Secret:     always,
+		Version: func() string {
+			return fmt.Sprint(VaultVersion)
+		},
+	}))
+
+	Register(withMeta(Registration{
+		Name:  Etcd,
+		Title: "etcd",
+		Backend: funcs{
+			profileExist:  etcd.ProfileExist,
+			listProfiles:  etcd.ListProfiles,
+			showProfile:   etcd.ShowProfile,
+			getProfile:    etcd.GetProfile,
+			addKVPair:     etcd.AddKVPair,
+			removeKVPair:  etcd.RemoveKVPair,
+			deleteProfile: etcd.DeleteProfile,
+		},
+		Configured: func() bool {
+			return len(viper.GetStringSlice("etcdEndpoints")) > 0
+		},
+		Source: etcd.Source,
+	}))
+
+	Register(withMeta(Registration{
+		Name:  S3,
+		Title: "S3",
+		Backend: funcs{
+			profileExist:  s3.ProfileExist,
+			listProfiles:  s3.ListProfiles,
+			showProfile:   s3.ShowProfile,
+			getProfile:    s3.GetProfile,
+			addKVPair:     s3.AddKVPair,
+			removeKVPair:  s3.RemoveKVPair,
+			deleteProfile: s3.DeleteProfile,
+		},
+		Configured: func() bool {
+			return viper.GetString("s3Bucket") != ""
+		},
+		Source: s3.Source,
+		// The profiles encrypted client-side are secrets:
+		Secret: func(string) bool {
+			return s3.Encrypted()
+		},
+	}))
+
+	Register(withMeta(Registration{
+		Name:  SecretsManager,
+		Title: "Secrets Manager",
+		Backend: funcs{
+			profileExist: secretsmanager.ProfileExist,
+			listProfiles: secretsmanager.ListProfiles,
+			showProfile: func(profileName string) ([]string, error) {
+				return secretsmanager.ShowProfile(profileName, SecretsManagerStage)
+			},
+			getProfile: func(profileName string) (map[string]string, error) {
+				return secretsmanager.GetProfile(profileName, SecretsManagerStage)
+			},
+			addKVPair:    secretsmanager.AddKVPair,
+			removeKVPair: secretsmanager.RemoveKVPair,
+			deleteProfile: func(profileName string) error {
+				return secretsmanager.DeleteProfile(profileName, SecretsManagerForce)
+			},
+		},
+		Configured: secretsManagerConfigured,
+		Source:     secretsmanager.Source,
+		Secret:     always,
+		Version: func() string {
+			return SecretsManagerStage
+		},
+	}))
+
+	Register(withMeta(Registration{
+		Name:  Pass,
+		Title: "pass",
+		Local: true,
+		Backend: funcs{
+			profileExist:  pass.ProfileExist,
+			listProfiles:  pass.ListProfiles,
+			showProfile:   pass.ShowProfile,
+			getProfile:    pass.GetProfile,
+			addKVPair:     pass.AddKVPair,
+			removeKVPair:  pass.RemoveKVPair,
+			deleteProfile: pass.DeleteProfile,
+		},
+		Configured: pass.Configured,
+		Source:     pass.Source,
+		Secret:     always,
+	}))
+
+	Register(withMeta(Registration{
+		Name:  K8s,
+		Title: "Kubernetes",
+		Backend: funcs{
+			profileExist:  k8s.ProfileExist,
+			listProfiles:  k8s.ListProfiles,
+			showProfile:   k8s.ShowProfile,
+			getProfile:    k8s.GetProfile,
+			addKVPair:     k8s.AddKVPair,
+			removeKVPair:  k8s.RemoveKVPair,
+			deleteProfile: k8s.DeleteProfile,
+		},
+		Configured: k8sConfigured,
+		Source:     k8s.Source,
+		Secret:     always,
+	}))
+
+	Register(Registration{
+		Name:  HTTP,
+		Title: "HTTP",
+		Backend: funcs{
+			profileExist:  rest.ProfileExist,
+			listProfiles:  rest.ListProfiles,
+			showProfile:   rest.ShowProfile,
+			getProfile:    rest.GetProfile,
+			addKVPair:     rest.AddKVPair,
+			removeKVPair:  rest.RemoveKVPair,
+			deleteProfile: rest.DeleteProfile,
+		},
+		Configured: func() bool {
+			return viper.GetString("httpAddress") != ""
+		},
+		Source:  rest.Source,
+		GetMeta: rest.GetMeta,
+	})
+}
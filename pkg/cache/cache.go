@@ -0,0 +1,275 @@
+// Package cache keep an encrypted copy on disk of the remote profiles fetched
+// by `use`, so that they remain usable offline or when their backend is
+// unreachable.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/*ErrNotCached is returned when the profile has no usable copy in the cache*/
+var ErrNotCached = errors.New("not cached")
+
+/*ErrOtherSource is returned when the cached profile has been fetched from another source than the configured one*/
+var ErrOtherSource = errors.New("cached from another source")
+
+/*Entry describe a cached profile*/
+type Entry struct {
+	Backend string    `json:"backend"`
+	Profile string    `json:"profile"`
+	Source  string    `json:"source"`
+	Fetched time.Time `json:"fetched"`
+	Expires time.Time `json:"expires"`
+}
+
+/*Name return the `<backend>/<profile>` name of the entry*/
+func (e Entry) Name() string {
+	return e.Backend + "/" + e.Profile
+}
+
+/*Expired tell if the entry is older than the TTL it was cached with*/
+func (e Entry) Expired() bool {
+	return time.Now().After(e.Expires)
+}
+
+// cacheFile is the on disk representation of a cached profile, the variables
+// being encrypted
+type cacheFile struct {
+	Entry
+	Data []byte `json:"data"`
+}
+
+/*Folder return the cache folder, `offlineCacheFolder` or profiler/offline in the user cache directory*/
+func Folder() (string, error) {
+	if folder := viper.GetString("offlineCacheFolder"); folder != "" {
+		return folder, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "profiler", "offline"), nil
+}
+
+// entryFile return the file of the given profile, named after a hash of
+// `<backend>/<profile>` to not expose the profile names
+func entryFile(folder string, backend string, profileName string) string {
+	sum := sha256.Sum256([]byte(backend + "/" + profileName))
+	return filepath.Join(folder, hex.EncodeToString(sum[:])+".json")
+}
+
+/*Enabled tell if the fetched profiles are cached, `offlineCacheTTL` being 0 disabling the cache*/
+func Enabled() bool {
+	return viper.GetDuration("offlineCacheTTL") > 0
+}
+
+/*Offline tell if profiler runs with --offline, the remote profiles being then only read from the cache*/
+func Offline() bool {
+	return viper.GetBool("offline")
+}
+
+/*Store cache the variables of the given profile, fetched from source, for `offlineCacheTTL`*/
+func Store(backend string, profileName string, source string, vars map[string]string) error {
+	folder, err := Folder()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(folder, 0700)
+	if err != nil {
+		return err
+	}
+
+	key, err := encryptionKey(folder)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	entry := Entry{
+		Backend: backend,
+		Profile: profileName,
+		Source:  source,
+		Fetched: now,
+		Expires: now.Add(viper.GetDuration("offlineCacheTTL")),
+	}
+
+	data, err := encrypt(key, plaintext, []byte(entry.Name()))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(cacheFile{Entry: entry, Data: data})
+	if err != nil {
+		return err
+	}
+
+	// Written aside then renamed, to never leave a truncated entry:
+	file := entryFile(folder, backend, profileName)
+	tmp, err := ioutil.TempFile(folder, ".entry-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// TempFile already creates the file 0600:
+	return os.Rename(tmp.Name(), file)
+}
+
+func readEntry(file string) (cacheFile, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cacheFile{}, err
+	}
+
+	var c cacheFile
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return cacheFile{}, fmt.Errorf("invalid cache entry %s: %w", file, err)
+	}
+
+	return c, nil
+}
+
+/*Load return the cached variables of the given profile, fetched from source, with their entry. It returns ErrNotCached when the profile isn't cached or its entry expired, ErrOtherSource when it has been cached from another source (e.g. another region or Consul agent).*/
+func Load(backend string, profileName string, source string) (map[string]string, Entry, error) {
+	folder, err := Folder()
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	c, err := readEntry(entryFile(folder, backend, profileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Entry{}, ErrNotCached
+	}
+	if err != nil {
+		return nil, Entry{}, err
+	}
+	if c.Expired() {
+		return nil, c.Entry, ErrNotCached
+	}
+	if c.Source != source {
+		return nil, c.Entry, ErrOtherSource
+	}
+
+	key, err := encryptionKey(folder)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	plaintext, err := decrypt(key, c.Data, []byte(c.Name()))
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	var vars map[string]string
+	err = json.Unmarshal(plaintext, &vars)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	return vars, c.Entry, nil
+}
+
+/*List return the cached profiles, expired ones included, sorted by name*/
+func List() ([]Entry, error) {
+	folder, err := Folder()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(folder, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		c, err := readEntry(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, c.Entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+/*Remove drop the given profile from the cache*/
+func Remove(backend string, profileName string) error {
+	folder, err := Folder()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(entryFile(folder, backend, profileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s/%s is not cached", backend, profileName)
+	}
+
+	return err
+}
+
+/*Clear drop every cached profile, and the generated encryption key*/
+func Clear() error {
+	folder, err := Folder()
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(folder, "*.json"))
+	if err != nil {
+		return err
+	}
+	files = append(files, filepath.Join(folder, keyFileName))
+
+	for _, file := range files {
+		err = os.Remove(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*ParseName split a `<backend>/<profile>` name*/
+func ParseName(name string) (string, string, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid cached profile %s, expecting <backend>/<profile>", name)
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// keyFileName is the key generated in the cache folder when
+// `offlineCacheKeyFile` isn't configured
+const keyFileName = "key"
+
+// encryptionKey return the base64 encoded 32 bytes key of
+// `offlineCacheKeyFile`, or the one of the cache folder, generated on first use.
+// The generated key lies next to the ciphertext: it only protects the entries
+// copied without it, `offlineCacheKeyFile` has to be used to keep the key
+// apart.
+func encryptionKey(folder string) ([]byte, error) {
+	file := viper.GetString("offlineCacheKeyFile")
+	if file == "" {
+		file = filepath.Join(folder, keyFileName)
+		err := generateKey(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid cache encryption key: expecting 32 bytes, got %d", len(key))
+	}
+
+	return key, nil
+}
+
+// generateKey write a new random key in the given file, unless it exists
+func generateKey(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 32)
+	_, err = io.ReadFull(rand.Reader, key)
+	if err == nil {
+		_, err = f.WriteString(base64.StdEncoding.EncodeToString(key))
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+	}
+
+	return err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt return the nonce followed by the sealed plaintext, bound to the
+// given additional data
+func encrypt(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("the cached profile is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt the cached profile (wrong cache encryption key?): %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// warnings receive the warnings about the cached profiles being used
+var warnings io.Writer = os.Stderr
+
+/*SetWarningOutput set where the warnings about the use of cached profiles are written (stderr by default)*/
+func SetWarningOutput(w io.Writer) {
+	warnings = w
+}
+
+/*Fetch get the given remote profile and keep a copy of it in the cache, which is used instead in offline mode or when the backend is unreachable. stale tell if the cached copy has been returned.*/
+func Fetch(backend string, profileName string, source string, get func(string) (map[string]string, error)) (vars map[string]string, stale bool, err error) {
+	if Offline() {
+		vars, err = loadStale(backend, profileName, source, "offline")
+		return vars, err == nil, err
+	}
+
+	vars, err = get(profileName)
+	if err != nil {
+		if Enabled() && Unreachable(err) {
+			cached, cacheErr := loadStale(backend, profileName, source, fmt.Sprintf("%s unreachable (%s)", backend, err))
+			if cacheErr == nil {
+				return cached, true, nil
+			}
+		}
+
+		return vars, false, err
+	}
+
+	if Enabled() {
+		err = Store(backend, profileName, source, vars)
+		if err != nil {
+			fmt.Fprintf(warnings, "Warning: the %s/%s profile could not be cached: %s\n", backend, profileName, err)
+		}
+	}
+
+	return vars, false, nil
+}
+
+// loadStale return the cached copy of the given profile, warning about its age
+func loadStale(backend string, profileName string, source string, reason string) (map[string]string, error) {
+	vars, entry, err := Load(backend, profileName, source)
+	if errors.Is(err, ErrOtherSource) {
+		return vars, fmt.Errorf("%s: the %s profile is cached from %s, not %s", reason, entry.Name(), entry.Source, source)
+	}
+	if errors.Is(err, ErrNotCached) && entry.Backend != "" {
+		return vars, fmt.Errorf("%s: the cached %s profile expired on %s", reason, entry.Name(), entry.Expires.Local().Format(time.RFC3339))
+	}
+	if errors.Is(err, ErrNotCached) {
+		return vars, fmt.Errorf("%s: the %s/%s profile is not cached", reason, backend, profileName)
+	}
+	if err != nil {
+		return vars, err
+	}
+
+	fmt.Fprintf(
+		warnings,
+		"Warning: %s, using the %s profile cached from %s, stale since %s\n",
+		reason,
+		entry.Name(),
+		entry.Source,
+		entry.Fetched.Local().Format(time.RFC3339),
+	)
+
+	return vars, nil
+}
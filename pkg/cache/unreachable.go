@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"errors"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+/*Unreachable tell if the given backend error is a network failure, rather than an error of the backend itself (access denied, missing profile...)*/
+func Unreachable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// The AWS SDK errors don't unwrap to their cause:
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		if awsErr.Code() == request.ErrCodeRequestError {
+			return true
+		}
+		return awsErr.OrigErr() != nil && Unreachable(awsErr.OrigErr())
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+// Package completion cache the shell completion values read from the remote
+// backends. Each tab completion being a new process, the remote backends
+// would otherwise be called on every key press.
+package completion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// entry is the on disk representation of cached completion values
+type entry struct {
+	Time   time.Time `json:"time"`
+	Values []string  `json:"values"`
+}
+
+// cacheFile return the file caching the given completion. Its name holds a
+// hash of the source of the values and of the configuration file, so that
+// changing the backend configuration doesn't serve the values of the previous
+// one.
+func cacheFile(name string, source string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(name + "\x00" + source + "\x00" + viper.ConfigFileUsed()))
+
+	// Profile names may contain '/', flatten them in the file name:
+	name = strings.ReplaceAll(name, "/", "_") + "-" + hex.EncodeToString(sum[:8])
+
+	return filepath.Join(cacheDir, "profiler", "completion", name+".json"), nil
+}
+
+/*Cached return the cached values of the given completion, read from source, if they are younger than `completionCacheTTL`, otherwise it calls fn and caches its result*/
+func Cached(name string, source string, fn func() ([]string, error)) ([]string, error) {
+	ttl := viper.GetDuration("completionCacheTTL")
+
+	file, err := cacheFile(name, source)
+	if err != nil || ttl <= 0 {
+		return fn()
+	}
+
+	if b, err := ioutil.ReadFile(file); err == nil {
+		var e entry
+		if json.Unmarshal(b, &e) == nil && time.Since(e.Time) < ttl {
+			return e.Values, nil
+		}
+	}
+
+	values, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(entry{Time: time.Now(), Values: values})
+	if err == nil && os.MkdirAll(filepath.Dir(file), 0700) == nil {
+		// Failing to write the cache should not prevent the completion:
+		_ = ioutil.WriteFile(file, b, 0600)
+	}
+
+	return values, nil
+}
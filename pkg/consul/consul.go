@@ -1,31 +1,99 @@
 package consul
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/julienlevasseur/profiler/pkg/meta"
 )
 
-func stringToByteSlice(value string) ([]byte, error) {
-	r := strings.NewReader(value)
-	b, err := ioutil.ReadAll(r)
-	if err != nil {
-		return []byte{}, err
+/*KV is the subset of the Consul KV API used by Profiler*/
+type KV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error)
+	Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
+	Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error)
+}
+
+const (
+	maxCASAttempts = 5
+	casRetryDelay  = 100 * time.Millisecond
+)
+
+/*ErrConflict is returned when a profile keeps being modified concurrently*/
+var ErrConflict = errors.New("the profile has been concurrently modified, please retry")
+
+// kvStore is the KV client used by the package, when set it replaces the
+// client built from the configuration
+var kvStore KV
+
+/*SetKV override the Consul KV client used by the package (nil restore the default one)*/
+func SetKV(kv KV) {
+	kvStore = kv
+}
+
+/*NewConsulConfig build the Consul client configuration from the profiler configuration, falling back on the standard CONSUL_* environment variables (CONSUL_HTTP_ADDR, CONSUL_CACERT, CONSUL_NAMESPACE, ...) for unset options*/
+func NewConsulConfig() *api.Config {
+	config := api.DefaultConfig()
+
+	setString := func(target *string, key string) {
+		if viper.GetString(key) != "" {
+			*target = viper.GetString(key)
+		}
 	}
 
-	return b, err
+	setString(&config.Address, "consulAddress")
+	setString(&config.Token, "consulToken")
+	setString(&config.TokenFile, "consulTokenFile")
+	setString(&config.Datacenter, "consulDatacenter")
+	setString(&config.Namespace, "consulNamespace")
+	setString(&config.Partition, "consulPartition")
+	setString(&config.TLSConfig.CAFile, "consulCAFile")
+	setString(&config.TLSConfig.CAPath, "consulCAPath")
+	setString(&config.TLSConfig.CertFile, "consulClientCert")
+	setString(&config.TLSConfig.KeyFile, "consulClientKey")
+	setString(&config.TLSConfig.Address, "consulTLSServerName")
+
+	if viper.IsSet("consulInsecureSkipVerify") {
+		config.TLSConfig.InsecureSkipVerify = viper.GetBool("consulInsecureSkipVerify")
+	}
+
+	return config
 }
 
-func newConsulAPIClient() (*api.Client, error) {
-	client, err := api.NewClient(&api.Config{
-		Address:   viper.GetString("consulAddress"),
-		TokenFile: viper.GetString("consulTokenFile"),
-		Token:     viper.GetString("consulToken"),
-	})
+/*Source describe where the Consul profiles are read from: the agent address, datacenter, namespace and partition*/
+func Source() string {
+	config := NewConsulConfig()
 
+	address := config.Address
+	if !strings.Contains(address, "://") {
+		address = config.Scheme + "://" + address
+	}
+
+	return strings.Join([]string{
+		address,
+		config.Datacenter,
+		config.Namespace,
+		config.Partition,
+	}, " ")
+}
+
+/*Configured tell if a Consul agent is configured, with `consulAddress` or the CONSUL_HTTP_ADDR environment variable*/
+func Configured() bool {
+	return viper.GetString("consulAddress") != "" || os.Getenv(api.HTTPAddrEnvName) != ""
+}
+
+func newConsulAPIClient() (*api.Client, error) {
+	client, err := api.NewClient(NewConsulConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -33,20 +101,32 @@ func newConsulAPIClient() (*api.Client, error) {
 	return client, nil
 }
 
+func newConsulKV() (KV, error) {
+	if kvStore != nil {
+		return kvStore, nil
+	}
+
+	client, err := newConsulAPIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.KV(), nil
+}
+
 /*ProfileExist return a boolean representation of the given profile existence*/
 func ProfileExist(profileName string) (bool, error) {
-	kvs, err := getKVPairs("profiler/" + profileName)
+	consul, err := newConsulKV()
 	if err != nil {
 		return false, err
 	}
 
-	for _, kv := range kvs {
-		if kv.Key == "profiler/"+profileName {
-			return true, nil
-		}
+	p, err := readProfile(consul, profileName)
+	if err != nil {
+		return false, err
 	}
 
-	return false, nil
+	return p.exists, nil
 }
 
 /*ListProfiles return the name of the Consul profiles as []string*/
@@ -56,25 +136,93 @@ func ListProfiles() ([]string, error) {
 		return []string{}, err
 	}
 
-	// Consul list will return the `profiler` folder as a KV, removing it from
-	// the slice because it doesn't need to be displayed:
-	kvs = kvs[1:]
-
 	var profiles []string
 	for _, kv := range kvs {
 		// Keys are named `profiler/Key`, removing the `profiler/` part for visibility:
-		profiles = append(profiles, strings.Split(kv.Key, "/")[1])
+		profileName := strings.Split(kv.Key, "/")[1]
+
+		// Consul list will return the `profiler` folder as a KV and the
+		// profiles metadata as sub keys, they don't need to be displayed:
+		if profileName == "" || profileAlreadyListed(profiles, profileName) {
+			continue
+		}
+
+		profiles = append(profiles, profileName)
 	}
 	return profiles, nil
 }
 
+func profileAlreadyListed(profiles []string, searchedProfile string) bool {
+	for _, i := range profiles {
+		if i == searchedProfile {
+			return true
+		}
+	}
+
+	return false
+}
+
+func metaKey(profileName string) string {
+	return fmt.Sprintf("profiler/%s/%s", profileName, meta.Key)
+}
+
+/*GetMeta retrieve the profile metadata stored under `profiler/<profile>/_meta`*/
+func GetMeta(profileName string) (meta.Meta, error) {
+	var m meta.Meta
+
+	consul, err := newConsulKV()
+	if err != nil {
+		return m, err
+	}
+
+	kv, _, err := consul.Get(metaKey(profileName), nil)
+	if err != nil {
+		return m, err
+	}
+
+	// No metadata set for this profile:
+	if kv == nil {
+		return m, nil
+	}
+
+	err = yaml.Unmarshal(kv.Value, &m)
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+/*SetMeta store the profile metadata under `profiler/<profile>/_meta`*/
+func SetMeta(profileName string, m meta.Meta) error {
+	consul, err := newConsulKV()
+	if err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = consul.Put(&api.KVPair{
+		Key:   metaKey(profileName),
+		Value: b,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func getKVPairs(path string) (api.KVPairs, error) {
-	consul, err := newConsulAPIClient()
+	consul, err := newConsulKV()
 	if err != nil {
 		return api.KVPairs{}, err
 	}
 
-	kvs, _, err := consul.KV().List(path, nil)
+	kvs, _, err := consul.List(path, nil)
 	if err != nil {
 		return api.KVPairs{}, err
 	}
@@ -84,22 +232,26 @@ func getKVPairs(path string) (api.KVPairs, error) {
 
 /*GetKVPair retrieve a single KV from Consul*/
 func GetKVPair(key string) (api.KVPair, error) {
-	consul, err := newConsulAPIClient()
+	consul, err := newConsulKV()
 	if err != nil {
 		return api.KVPair{}, err
 	}
 
-	kv, _, err := consul.KV().Get(key, nil)
+	kv, _, err := consul.Get(key, nil)
 	if err != nil {
 		return api.KVPair{}, err
 	}
 
+	if kv == nil {
+		return api.KVPair{}, fmt.Errorf("key %s not found", key)
+	}
+
 	return *kv, nil
 }
 
 /*CreateProfilerFolder create the `/profiler` KV folder as the profiles placeholder in Consul*/
 func CreateProfilerFolder() error {
-	consul, err := newConsulAPIClient()
+	consul, err := newConsulKV()
 	if err != nil {
 		return err
 	}
@@ -107,156 +259,136 @@ func CreateProfilerFolder() error {
 	profile := &api.KVPair{
 		Key: "profiler/",
 	}
-	consul.KV().Put(profile, nil)
+	_, err = consul.Put(profile, nil)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
-/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
-func AddKVPair(profileName string, KVs []string) error {
-	consul, err := newConsulAPIClient()
+func profileKey(profileName string) string {
+	return fmt.Sprintf("profiler/%s", profileName)
+}
+
+// updateProfile apply fn to the variables of the profile (created if it
+// doesn't exist yet) and write them back in a transaction conditioned on the
+// ModifyIndex read, retrying when the profile has been concurrently modified
+func updateProfile(profileName string, fn func(vars map[string]string) error) error {
+	consul, err := newConsulKV()
 	if err != nil {
 		return err
 	}
 
-	if len(KVs) == 0 {
-		// Create an empty profile:
-		b, err := stringToByteSlice(
-			fmt.Sprintf("profile_name: %s\n", profileName),
-		)
+	return retryTxn(consul, func() (api.KVTxnOps, error) {
+		p, err := readProfile(consul, profileName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		profile := &api.KVPair{
-			Key:   fmt.Sprintf("profiler/%s\n", profileName),
-			Value: b,
+		vars := make(map[string]string)
+		for k, v := range p.vars {
+			vars[k] = v
+		}
+		if !p.exists {
+			vars["profile_name"] = profileName
 		}
-		consul.KV().Put(profile, nil)
-	} else {
-		var b []byte
-		var actualKVPair api.KVPair
 
-		exist, err := ProfileExist(profileName)
+		err = fn(vars)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if exist {
-			actualKVPair, err = GetKVPair(
-				fmt.Sprintf("/profiler/%s", profileName),
-			)
-			if err != nil {
-				return err
-			}
-		}
+		return p.replaceOps(profileName, vars, p.layout)
+	})
+}
 
-		for i := 0; i <= len(KVs)/2; i++ {
-			var k string
-			var v string
-
-			k, KVs = KVs[i], KVs[1:]
-
-			if len(KVs)/2 > 1 {
-				v, KVs = KVs[i], KVs[1:]
-			} else {
-				v = KVs[i]
-			}
-
-			// Checking if provided argument is already present in KVPair:
-			lines := strings.Split(string(actualKVPair.Value), "\n")
-			for _, line := range lines {
-				var key string
-				var value string
-
-				// Ignoring empty line:
-				if len(line) == 0 {
-					continue
-				} else {
-					kv := strings.Split(line, ": ")
-					key = kv[0]
-					value = kv[1]
-				}
-
-				if k == key && v == value {
-					continue
-				} else if k == key {
-					// if the Key already exist but the value is different, it
-					// will be handled as a new KV.
-					continue
-				} else {
-					alreadyPresentKV, err := stringToByteSlice(
-						fmt.Sprintf("%s: %s\n", key, value),
-					)
-					if err != nil {
-						return err
-					}
-
-					b = append(b, alreadyPresentKV...)
-				}
-			}
-
-			if i%2 == 0 {
-				KV, err := stringToByteSlice(
-					fmt.Sprintf("%s: %s\n", k, v),
-				)
-				if err != nil {
-					return err
-				}
-
-				b = append(b, KV...)
-			}
-
-			if i == 1 {
-				break
-			}
-		}
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
 
-		profile := &api.KVPair{
-			Key:   fmt.Sprintf("profiler/%s", profileName),
-			Value: b,
+	return updateProfile(profileName, func(vars map[string]string) error {
+		for i := 0; i < len(KVs); i += 2 {
+			vars[KVs[i]] = KVs[i+1]
 		}
-		err = DeleteKey(fmt.Sprintf("profiler/%s", profileName))
-		if err != nil {
-			return err
+
+		return nil
+	})
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	return updateProfile(profileName, func(vars map[string]string) error {
+		if _, ok := vars[key]; !ok {
+			return fmt.Errorf("%s not found in the %s profile", key, profileName)
 		}
-		consul.KV().Put(profile, nil)
+
+		delete(vars, key)
+
+		return nil
+	})
+}
+
+/*DeleteProfile delete the given profile and its metadata*/
+func DeleteProfile(profileName string) error {
+	consul, err := newConsulKV()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	_, err = commit(consul, api.KVTxnOps{
+		{Verb: api.KVDelete, Key: profileKey(profileName)},
+		{Verb: api.KVDeleteTree, Key: profileKey(profileName) + "/"},
+	})
+
+	return err
 }
 
 /*ShowProfile return the list of keys for a profile*/
 func ShowProfile(profileName string) ([]string, error) {
-	var keys []string
-
-	kv, err := GetKVPair("profiler/" + profileName)
+	vars, err := GetProfile(profileName)
 	if err != nil {
 		return []string{}, err
 	}
 
-	lines := strings.Split(string(kv.Value), "\n")
-	for _, line := range lines {
-		// Ignoring empty line:
-		if len(line) == 0 {
-			continue
-		} else {
-			kv := strings.Split(line, ": ")
-			keys = append(keys, kv[0])
-		}
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
 	return keys, nil
 }
 
+/*GetProfile retrieve the given profile variables from Consul*/
+func GetProfile(profileName string) (map[string]string, error) {
+	consul, err := newConsulKV()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	p, err := readProfile(consul, profileName)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	if !p.exists {
+		return map[string]string{}, fmt.Errorf("key %s not found", profileKey(profileName))
+	}
+
+	return p.vars, nil
+}
+
 /*DeleteKey delete a Consul Key*/
 func DeleteKey(key string) error {
-	consul, err := newConsulAPIClient()
+	consul, err := newConsulKV()
 	if err != nil {
 		return err
 	}
 
-	_, err = consul.KV().Delete(key, nil)
+	_, err = consul.Delete(key, nil)
 	if err != nil {
 		return err
 	}
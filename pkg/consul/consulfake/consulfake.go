@@ -0,0 +1,270 @@
+// Package consulfake provides an in-memory Consul KV store implementing
+// consul.KV, used to test the Consul backend offline.
+package consulfake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// KV is an in-memory Consul KV store
+type KV struct {
+	mutex   sync.Mutex
+	pairs   map[string]*api.KVPair
+	index   uint64
+	changed chan struct{}
+
+	// Err, when set, is returned by every call
+	Err error
+}
+
+// New return an empty KV store
+func New() *KV {
+	return &KV{
+		pairs:   make(map[string]*api.KVPair),
+		changed: make(chan struct{}),
+	}
+}
+
+func copyPair(p *api.KVPair) *api.KVPair {
+	c := *p
+	c.Value = append([]byte(nil), p.Value...)
+
+	return &c
+}
+
+// wait block, like a Consul blocking query, until the store index is greater
+// than the query WaitIndex, the WaitTime elapses or the query context is done.
+// The caller holds the lock, which is released while waiting.
+func (kv *KV) wait(q *api.QueryOptions) {
+	if q == nil || q.WaitIndex == 0 {
+		return
+	}
+
+	timeout := time.After(q.WaitTime)
+	for kv.index <= q.WaitIndex {
+		changed := kv.changed
+
+		kv.mutex.Unlock()
+		select {
+		case <-changed:
+			kv.mutex.Lock()
+		case <-timeout:
+			kv.mutex.Lock()
+			return
+		case <-q.Context().Done():
+			kv.mutex.Lock()
+			return
+		}
+	}
+}
+
+// notify wake up the blocked queries, the caller holding the lock
+func (kv *KV) notify() {
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+// Get return the pair stored at key, nil if it doesn't exist. It blocks when
+// the query has a WaitIndex.
+func (kv *KV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.wait(q)
+
+	if q != nil && q.Context().Err() != nil {
+		return nil, nil, q.Context().Err()
+	}
+
+	if kv.Err != nil {
+		return nil, nil, kv.Err
+	}
+
+	meta := &api.QueryMeta{LastIndex: kv.index}
+	p, ok := kv.pairs[key]
+	if !ok {
+		return nil, meta, nil
+	}
+
+	return copyPair(p), meta, nil
+}
+
+// List return the pairs whose key starts with prefix, sorted by key. It
+// blocks when the query has a WaitIndex.
+func (kv *KV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	kv.wait(q)
+
+	if q != nil && q.Context().Err() != nil {
+		return nil, nil, q.Context().Err()
+	}
+
+	if kv.Err != nil {
+		return nil, nil, kv.Err
+	}
+
+	var pairs api.KVPairs
+	for key, p := range kv.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, copyPair(p))
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	return pairs, &api.QueryMeta{LastIndex: kv.index}, nil
+}
+
+// put store the pair, the caller holding the lock
+func (kv *KV) put(p *api.KVPair) {
+	kv.index++
+	kv.store(p)
+	kv.notify()
+}
+
+// store save the pair at the current index, the caller holding the lock
+func (kv *KV) store(p *api.KVPair) *api.KVPair {
+	stored := copyPair(p)
+	stored.ModifyIndex = kv.index
+	stored.CreateIndex = kv.index
+	if existing, ok := kv.pairs[p.Key]; ok {
+		stored.CreateIndex = existing.CreateIndex
+	}
+
+	kv.pairs[p.Key] = stored
+
+	return copyPair(stored)
+}
+
+// indexMatches tell if the stored pair (ok if it exists) has the given
+// ModifyIndex, a zero index matching a missing key
+func indexMatches(existing *api.KVPair, ok bool, index uint64) bool {
+	if index == 0 {
+		return !ok
+	}
+
+	return ok && existing.ModifyIndex == index
+}
+
+// Put store the given pair
+func (kv *KV) Put(p *api.KVPair, w *api.WriteOptions) (*api.WriteMeta, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if kv.Err != nil {
+		return nil, kv.Err
+	}
+
+	kv.put(p)
+
+	return &api.WriteMeta{}, nil
+}
+
+// CAS store the given pair only if its ModifyIndex matches the stored one
+// (or if the key doesn't exist when ModifyIndex is 0)
+func (kv *KV) CAS(p *api.KVPair, w *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if kv.Err != nil {
+		return false, nil, kv.Err
+	}
+
+	existing, ok := kv.pairs[p.Key]
+	if !indexMatches(existing, ok, p.ModifyIndex) {
+		return false, &api.WriteMeta{}, nil
+	}
+
+	kv.put(p)
+
+	return true, &api.WriteMeta{}, nil
+}
+
+// Delete remove the pair stored at key
+func (kv *KV) Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if kv.Err != nil {
+		return nil, kv.Err
+	}
+
+	kv.index++
+	delete(kv.pairs, key)
+	kv.notify()
+
+	return &api.WriteMeta{}, nil
+}
+
+// Txn apply the operations atomically at a single index: nothing is written
+// if one of the conditions fails
+func (kv *KV) Txn(ops api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+
+	if kv.Err != nil {
+		return false, nil, nil, kv.Err
+	}
+
+	var errs api.TxnErrors
+	for i, op := range ops {
+		existing, ok := kv.pairs[op.Key]
+
+		switch op.Verb {
+		case api.KVSet, api.KVDelete, api.KVDeleteTree:
+		case api.KVCAS, api.KVDeleteCAS, api.KVCheckIndex:
+			if !indexMatches(existing, ok, op.Index) {
+				errs = append(errs, &api.TxnError{
+					OpIndex: i,
+					What:    fmt.Sprintf("index of %s doesn't match %d", op.Key, op.Index),
+				})
+			}
+		case api.KVCheckNotExists:
+			if ok {
+				errs = append(errs, &api.TxnError{
+					OpIndex: i,
+					What:    fmt.Sprintf("%s exists", op.Key),
+				})
+			}
+		default:
+			return false, nil, nil, fmt.Errorf("unsupported transaction verb %s", op.Verb)
+		}
+	}
+
+	if len(errs) > 0 {
+		return false, &api.KVTxnResponse{Errors: errs}, &api.QueryMeta{LastIndex: kv.index}, nil
+	}
+
+	kv.index++
+	resp := &api.KVTxnResponse{}
+	for _, op := range ops {
+		switch op.Verb {
+		case api.KVSet, api.KVCAS:
+			resp.Results = append(resp.Results, kv.store(&api.KVPair{
+				Key:   op.Key,
+				Value: op.Value,
+				Flags: op.Flags,
+			}))
+		case api.KVDelete, api.KVDeleteCAS:
+			delete(kv.pairs, op.Key)
+		case api.KVDeleteTree:
+			for key := range kv.pairs {
+				if strings.HasPrefix(key, op.Key) {
+					delete(kv.pairs, key)
+				}
+			}
+		}
+	}
+	kv.notify()
+
+	return true, resp, &api.QueryMeta{LastIndex: kv.index}, nil
+}
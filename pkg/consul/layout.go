@@ -0,0 +1,286 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/julienlevasseur/profiler/pkg/meta"
+)
+
+/*Profile layouts in the Consul KV store*/
+const (
+	// LayoutBlob store a profile as a single YAML value under `profiler/<profile>`
+	LayoutBlob = "blob"
+	// LayoutPerKey store every variable under `profiler/<profile>/<KEY>`
+	LayoutPerKey = "per-key"
+)
+
+// maxTxnOps is the maximum number of operations of a Consul transaction
+const maxTxnOps = 64
+
+// configuredLayout return the layout of the new profiles (`consulLayout`)
+func configuredLayout() (string, error) {
+	switch viper.GetString("consulLayout") {
+	case "", LayoutBlob:
+		return LayoutBlob, nil
+	case LayoutPerKey:
+		return LayoutPerKey, nil
+	default:
+		return "", fmt.Errorf("invalid consulLayout %s (expecting %s or %s)", viper.GetString("consulLayout"), LayoutBlob, LayoutPerKey)
+	}
+}
+
+func variableKey(profileName string, key string) string {
+	return fmt.Sprintf("%s/%s", profileKey(profileName), key)
+}
+
+// storedProfile is a profile as stored in Consul, whatever its layout
+type storedProfile struct {
+	layout string
+	exists bool
+	vars   map[string]string
+
+	// ModifyIndex of the blob, or of every variable in the per-key layout:
+	index   uint64
+	indexes map[string]uint64
+}
+
+// profileFromPairs build the profile from the pairs listed under its key.
+// Profiles keep the layout they are stored with, the blob winning if both
+// are found.
+func profileFromPairs(profileName string, pairs api.KVPairs) (storedProfile, error) {
+	p := storedProfile{
+		vars:    make(map[string]string),
+		indexes: make(map[string]uint64),
+	}
+
+	prefix := profileKey(profileName) + "/"
+	for _, kv := range pairs {
+		if kv.Key == profileKey(profileName) {
+			p.layout = LayoutBlob
+			p.exists = true
+			p.index = kv.ModifyIndex
+			p.vars = make(map[string]string)
+			p.indexes = make(map[string]uint64)
+
+			err := yaml.Unmarshal(kv.Value, &p.vars)
+			if err != nil {
+				return p, err
+			}
+			break
+		}
+
+		key := strings.TrimPrefix(kv.Key, prefix)
+		// Other profiles sharing the prefix, the metadata and nested keys
+		// aren't variables:
+		if !strings.HasPrefix(kv.Key, prefix) || key == meta.Key || strings.Contains(key, "/") {
+			continue
+		}
+
+		p.layout = LayoutPerKey
+		p.exists = true
+		p.vars[key] = string(kv.Value)
+		p.indexes[key] = kv.ModifyIndex
+	}
+
+	if !p.exists {
+		layout, err := configuredLayout()
+		if err != nil {
+			return p, err
+		}
+		p.layout = layout
+	}
+
+	return p, nil
+}
+
+func readProfile(consul KV, profileName string) (storedProfile, error) {
+	pairs, _, err := consul.List(profileKey(profileName), nil)
+	if err != nil {
+		return storedProfile{}, err
+	}
+
+	return profileFromPairs(profileName, pairs)
+}
+
+// replaceOps return the transaction operations replacing the stored profile
+// with vars in the given layout. Every write or delete is conditioned on the
+// ModifyIndex read, so that the transaction fails if the profile changed.
+func (p storedProfile) replaceOps(profileName string, vars map[string]string, layout string) (api.KVTxnOps, error) {
+	var ops api.KVTxnOps
+
+	switch layout {
+	case LayoutBlob:
+		b, err := yaml.Marshal(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		// A zero index only writes the key if it doesn't exist yet:
+		ops = append(ops, &api.KVTxnOp{
+			Verb:  api.KVCAS,
+			Key:   profileKey(profileName),
+			Value: b,
+			Index: p.index,
+		})
+	case LayoutPerKey:
+		for k, v := range vars {
+			if k == meta.Key || strings.Contains(k, "/") {
+				return nil, fmt.Errorf("%s can't be stored with the %s layout", k, LayoutPerKey)
+			}
+
+			// Unchanged variables are left alone:
+			if old, ok := p.vars[k]; ok && old == v && p.layout == LayoutPerKey {
+				continue
+			}
+
+			ops = append(ops, &api.KVTxnOp{
+				Verb:  api.KVCAS,
+				Key:   variableKey(profileName, k),
+				Value: []byte(v),
+				Index: p.indexes[k],
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown layout %s", layout)
+	}
+
+	if p.layout == LayoutPerKey {
+		for k := range p.vars {
+			if _, ok := vars[k]; ok && layout == LayoutPerKey {
+				continue
+			}
+
+			ops = append(ops, &api.KVTxnOp{
+				Verb:  api.KVDeleteCAS,
+				Key:   variableKey(profileName, k),
+				Index: p.indexes[k],
+			})
+		}
+	} else if p.exists && layout != LayoutBlob {
+		ops = append(ops, &api.KVTxnOp{
+			Verb:  api.KVDeleteCAS,
+			Key:   profileKey(profileName),
+			Index: p.index,
+		})
+	}
+
+	return ops, nil
+}
+
+// commit run the operations in a single transaction, returning false when it
+// has been rolled back because of a concurrent modification
+func commit(consul KV, ops api.KVTxnOps) (bool, error) {
+	if len(ops) == 0 {
+		return true, nil
+	}
+
+	if len(ops) > maxTxnOps {
+		return false, fmt.Errorf("too many changes for a single Consul transaction (%d, maximum %d)", len(ops), maxTxnOps)
+	}
+
+	ok, _, _, err := consul.Txn(ops, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// retryTxn commit the operations returned by plan, calling it again with
+// fresh reads when the profile has been concurrently modified
+func retryTxn(consul KV, plan func() (api.KVTxnOps, error)) error {
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * casRetryDelay)
+		}
+
+		ops, err := plan()
+		if err != nil {
+			return err
+		}
+
+		ok, err := commit(consul, ops)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+	}
+
+	return ErrConflict
+}
+
+// migrationOps return the operations converting the profile to the given
+// layout, none when it already uses it
+func migrationOps(consul KV, profileName string, layout string) (api.KVTxnOps, error) {
+	if layout != LayoutBlob && layout != LayoutPerKey {
+		return nil, fmt.Errorf("unknown layout %s (expecting %s or %s)", layout, LayoutBlob, LayoutPerKey)
+	}
+
+	p, err := readProfile(consul, profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.exists {
+		return nil, fmt.Errorf("profile %s not found", profileName)
+	}
+
+	if p.layout == layout {
+		return nil, nil
+	}
+
+	ops, err := p.replaceOps(profileName, p.vars, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Migrations are atomic, a profile too large for a single transaction
+	// can't be converted:
+	if len(ops) > maxTxnOps {
+		return nil, fmt.Errorf(
+			"%d variables, too many to be converted in a single Consul transaction (maximum %d operations)",
+			len(p.vars), maxTxnOps,
+		)
+	}
+
+	return ops, nil
+}
+
+/*CheckMigration tell if the profile can be converted to the given layout, without writing anything*/
+func CheckMigration(profileName string, layout string) error {
+	consul, err := newConsulKV()
+	if err != nil {
+		return err
+	}
+
+	_, err = migrationOps(consul, profileName, layout)
+
+	return err
+}
+
+/*Migrate convert the profile to the given layout in a single transaction, returning false if it already uses it*/
+func Migrate(profileName string, layout string) (bool, error) {
+	consul, err := newConsulKV()
+	if err != nil {
+		return false, err
+	}
+
+	migrated := false
+	err = retryTxn(consul, func() (api.KVTxnOps, error) {
+		ops, err := migrationOps(consul, profileName, layout)
+		migrated = len(ops) > 0
+
+		return ops, err
+	})
+
+	return migrated, err
+}
@@ -0,0 +1,189 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	watchWaitTime      = 5 * time.Minute
+	watchMinRetryDelay = time.Second
+	watchMaxRetryDelay = time.Minute
+)
+
+// watchErrors receive the errors Watch recovers from
+var watchErrors io.Writer = os.Stderr
+
+/*SetWatchErrorOutput set where the errors Watch recovers from are written (stderr by default)*/
+func SetWatchErrorOutput(w io.Writer) {
+	watchErrors = w
+}
+
+/*Change is the update of a profile variable*/
+type Change struct {
+	Key  string
+	Kind string
+}
+
+/*Kinds of Change*/
+const (
+	Added   = "+"
+	Updated = "~"
+	Removed = "-"
+)
+
+/*Diff return the changes between two versions of a profile, sorted by key*/
+func Diff(previous, current map[string]string) []Change {
+	var changes []Change
+
+	for k, v := range current {
+		old, ok := previous[k]
+		if !ok {
+			changes = append(changes, Change{Key: k, Kind: Added})
+		} else if old != v {
+			changes = append(changes, Change{Key: k, Kind: Updated})
+		}
+	}
+
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			changes = append(changes, Change{Key: k, Kind: Removed})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// watchPairs return the pairs of the profile with a blocking query. The
+// profile is first looked for in both layouts, then only its key (blob) or the
+// keys under it (per-key) are queried, so that the profiles sharing its prefix
+// (dev2 for dev) are left out.
+func watchPairs(consul KV, profileName string, layout string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	switch layout {
+	case "":
+		return consul.List(profileKey(profileName), q)
+	case LayoutPerKey:
+		return consul.List(profileKey(profileName)+"/", q)
+	}
+
+	pair, meta, err := consul.Get(profileKey(profileName), q)
+	if err != nil || pair == nil {
+		return nil, meta, err
+	}
+
+	return api.KVPairs{pair}, meta, nil
+}
+
+// Watch call fn with the profile variables once loaded, then each time they
+// change (with the changes) until the context is cancelled. It relies on Consul
+// blocking queries and backs off when Consul can't be reached. The errors it
+// recovers from, like an unreadable revision of the profile, are reported
+// without ending the watch. When the profile is deleted, its last variables
+// are kept until it is created again.
+func Watch(ctx context.Context, profileName string, fn func(vars map[string]string, changes []Change)) error {
+	consul, err := newConsulKV()
+	if err != nil {
+		return err
+	}
+
+	var index uint64
+	var current map[string]string
+	var layout string
+	loaded := false
+	missing := false
+	retryDelay := watchMinRetryDelay
+
+	for {
+		q := (&api.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  watchWaitTime,
+		}).WithContext(ctx)
+
+		pairs, meta, err := watchPairs(consul, profileName, layout, q)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			fmt.Fprintf(watchErrors, "Error watching the %s profile: %s, retrying in %s\n", profileName, err, retryDelay)
+
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return nil
+			}
+
+			retryDelay *= 2
+			if retryDelay > watchMaxRetryDelay {
+				retryDelay = watchMaxRetryDelay
+			}
+			continue
+		}
+		retryDelay = watchMinRetryDelay
+
+		// Same index: the blocking query timed out without change.
+		if loaded && meta.LastIndex == index {
+			continue
+		}
+
+		// The index must only go forward, if it goes backward (e.g. after a
+		// snapshot restore), restart from scratch:
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		// A revision that can't be read is skipped, the next one may fix it:
+		p, err := profileFromPairs(profileName, pairs)
+		if err != nil {
+			fmt.Fprintf(watchErrors, "Ignoring the revision %d of the %s profile: %s\n", index, profileName, err)
+			continue
+		}
+		vars := p.vars
+
+		// The profile may have been migrated to the other layout rather than
+		// deleted, it is looked for in both right away:
+		if !p.exists && layout != "" && !missing {
+			layout = ""
+			index = 0
+			continue
+		}
+		layout = p.layout
+
+		if !loaded {
+			loaded = true
+			missing = !p.exists
+			current = vars
+			fn(vars, nil)
+			continue
+		}
+
+		if !p.exists {
+			if !missing {
+				fmt.Fprintf(watchErrors, "The %s profile has been deleted, keeping its last variables\n", profileName)
+			}
+			missing = true
+			continue
+		}
+		missing = false
+
+		changes := Diff(current, vars)
+		if len(changes) == 0 {
+			continue
+		}
+
+		current = vars
+		fn(vars, changes)
+	}
+}
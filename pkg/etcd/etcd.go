@@ -0,0 +1,274 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultEndpoint  = "127.0.0.1:2379"
+	defaultKeyPrefix = "/profiler/"
+	dialTimeout      = 5 * time.Second
+	requestTimeout   = 10 * time.Second
+)
+
+// keyPrefix return the prefix of the profiles keys (`etcdPrefix`), always
+// ending with a slash
+func keyPrefix() string {
+	prefix := viper.GetString("etcdPrefix")
+	if prefix == "" {
+		return defaultKeyPrefix
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// profileKey return the prefix of the keys of the given profile
+func profileKey(profileName string) string {
+	return keyPrefix() + profileName + "/"
+}
+
+func variableKey(profileName string, key string) string {
+	return profileKey(profileName) + key
+}
+
+// useTLS tell if the connection to etcd has to be secured
+func useTLS(endpoints []string) bool {
+	for _, e := range endpoints {
+		if strings.HasPrefix(e, "https://") {
+			return true
+		}
+	}
+
+	return viper.GetString("etcdCACert") != "" || viper.GetString("etcdClientCert") != ""
+}
+
+// endpoints return `etcdEndpoints`, the local etcd when not set
+func endpoints() []string {
+	endpoints := viper.GetStringSlice("etcdEndpoints")
+	if len(endpoints) == 0 {
+		return []string{defaultEndpoint}
+	}
+
+	return endpoints
+}
+
+/*Source describe where the etcd profiles are read from: the endpoints, user and prefix*/
+func Source() string {
+	return strings.Join([]string{
+		strings.Join(endpoints(), ","),
+		viper.GetString("etcdUsername"),
+		keyPrefix(),
+	}, " ")
+}
+
+// newClient build the etcd client from the profiler configuration, the caller
+// has to close it
+func newClient() (*clientv3.Client, error) {
+	config := clientv3.Config{
+		Endpoints:   endpoints(),
+		DialTimeout: dialTimeout,
+		Username:    viper.GetString("etcdUsername"),
+		Password:    viper.GetString("etcdPassword"),
+		// The client retries are reported through the returned errors:
+		Logger: zap.NewNop(),
+	}
+
+	if useTLS(config.Endpoints) {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile:      viper.GetString("etcdCACert"),
+			CertFile:           viper.GetString("etcdClientCert"),
+			KeyFile:            viper.GetString("etcdClientKey"),
+			InsecureSkipVerify: viper.GetBool("etcdInsecureSkipVerify"),
+		}
+
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.TLS = tlsConfig
+	}
+
+	return clientv3.New(config)
+}
+
+// withClient call fn with a client and a request context, closing both once
+// done
+func withClient(fn func(ctx context.Context, client *clientv3.Client) error) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	return fn(ctx, client)
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	exist := false
+
+	err := withClient(func(ctx context.Context, client *clientv3.Client) error {
+		resp, err := client.Get(ctx, profileKey(profileName), clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return err
+		}
+
+		exist = resp.Count > 0
+
+		return nil
+	})
+
+	return exist, err
+}
+
+/*ListProfiles return the name of the etcd profiles as []string*/
+func ListProfiles() ([]string, error) {
+	var profiles []string
+
+	err := withClient(func(ctx context.Context, client *clientv3.Client) error {
+		resp, err := client.Get(ctx, keyPrefix(), clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		if err != nil {
+			return err
+		}
+
+		listed := make(map[string]bool)
+		for _, kv := range resp.Kvs {
+			// Keys are named `<prefix><profile>/<KEY>`:
+			parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), keyPrefix()), "/", 2)
+			if len(parts) < 2 || listed[parts[0]] {
+				continue
+			}
+
+			listed[parts[0]] = true
+			profiles = append(profiles, parts[0])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+/*GetProfile retrieve the given profile variables from etcd*/
+func GetProfile(profileName string) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	err := withClient(func(ctx context.Context, client *clientv3.Client) error {
+		resp, err := client.Get(ctx, profileKey(profileName), clientv3.WithPrefix())
+		if err != nil {
+			return err
+		}
+
+		for _, kv := range resp.Kvs {
+			vars[strings.TrimPrefix(string(kv.Key), profileKey(profileName))] = string(kv.Value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	if len(vars) == 0 {
+		return map[string]string{}, fmt.Errorf("profile %s not found", profileName)
+	}
+
+	return vars, nil
+}
+
+/*ShowProfile return the list of keys for a profile*/
+func ShowProfile(profileName string) ([]string, error) {
+	vars, err := GetProfile(profileName)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName, atomically*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	// etcd refuses a transaction updating the same key twice, the last value
+	// given wins:
+	vars := make(map[string]string)
+	for i := 0; i < len(KVs); i += 2 {
+		if KVs[i] == "" || strings.Contains(KVs[i], "/") {
+			return fmt.Errorf("invalid variable name %q", KVs[i])
+		}
+		vars[KVs[i]] = KVs[i+1]
+	}
+
+	var puts []clientv3.Op
+	for k, v := range vars {
+		puts = append(puts, clientv3.OpPut(variableKey(profileName, k), v))
+	}
+
+	creates := puts
+	if _, ok := vars["profile_name"]; !ok {
+		creates = append([]clientv3.Op{clientv3.OpPut(variableKey(profileName, "profile_name"), profileName)}, puts...)
+	}
+
+	return withClient(func(ctx context.Context, client *clientv3.Client) error {
+		// The profile_name variable is only set when the profile is created:
+		nameKey := variableKey(profileName, "profile_name")
+		_, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(nameKey), "=", 0)).
+			Then(creates...).
+			Else(puts...).
+			Commit()
+
+		return err
+	})
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	return withClient(func(ctx context.Context, client *clientv3.Client) error {
+		resp, err := client.Delete(ctx, variableKey(profileName, key))
+		if err != nil {
+			return err
+		}
+
+		if resp.Deleted == 0 {
+			return fmt.Errorf("%s not found in the %s profile", key, profileName)
+		}
+
+		return nil
+	})
+}
+
+/*DeleteProfile delete every variable of the given profile*/
+func DeleteProfile(profileName string) error {
+	return withClient(func(ctx context.Context, client *clientv3.Client) error {
+		_, err := client.Delete(ctx, profileKey(profileName), clientv3.WithPrefix())
+
+		return err
+	})
+}
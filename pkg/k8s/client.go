@@ -0,0 +1,101 @@
+package k8s
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clientset is the Kubernetes client used by the package, when set it
+// replaces the client built from the kubeconfig
+var clientset kubernetes.Interface
+
+/*SetClientset override the Kubernetes client used by the package (nil restore the default one)*/
+func SetClientset(c kubernetes.Interface) {
+	clientset = c
+}
+
+// clientConfig load the kubeconfig like kubectl does (KUBECONFIG then
+// ~/.kube/config) unless `k8sKubeconfig` is set, with the `k8sContext`
+// context instead of the current one when set
+func clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if viper.GetString("k8sKubeconfig") != "" {
+		rules.ExplicitPath = viper.GetString("k8sKubeconfig")
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: viper.GetString("k8sContext")}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+func newClient() (kubernetes.Interface, error) {
+	if clientset != nil {
+		return clientset, nil
+	}
+
+	config, err := clientConfig().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// namespace return `k8sNamespace`, or the namespace of the kubeconfig context
+func namespace() (string, error) {
+	if viper.GetString("k8sNamespace") != "" {
+		return viper.GetString("k8sNamespace"), nil
+	}
+	if clientset != nil {
+		return "default", nil
+	}
+
+	ns, _, err := clientConfig().Namespace()
+
+	return ns, err
+}
+
+/*Source describe where the Kubernetes profiles are read from: the cluster, context and namespace*/
+func Source() string {
+	ns, err := namespace()
+	if err != nil {
+		return ""
+	}
+
+	raw, err := clientConfig().RawConfig()
+	if err != nil {
+		return ns
+	}
+
+	context := viper.GetString("k8sContext")
+	if context == "" {
+		context = raw.CurrentContext
+	}
+
+	var server string
+	if c, ok := raw.Contexts[context]; ok {
+		if cluster, ok := raw.Clusters[c.Cluster]; ok {
+			server = cluster.Server
+		}
+	}
+
+	return strings.Join([]string{server, context, ns}, " ")
+}
+
+// connect return the client and the namespace of the profiles
+func connect() (kubernetes.Interface, string, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ns, err := namespace()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, ns, nil
+}
@@ -0,0 +1,334 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	defaultProfileLabel = "profiler/profile"
+
+	/*KindSecret store the written profiles as Secrets*/
+	KindSecret = "secret"
+	/*KindConfigMap store the written profiles as ConfigMaps*/
+	KindConfigMap = "configmap"
+)
+
+/*ErrReadOnly is returned by the writes when they are not enabled*/
+var ErrReadOnly = errors.New("the Kubernetes profiles are read-only, set k8sWritable to modify them")
+
+// profileLabel return the label holding the name of the profile of the
+// ConfigMaps and Secrets (`k8sProfileLabel`)
+func profileLabel() string {
+	if viper.GetString("k8sProfileLabel") == "" {
+		return defaultProfileLabel
+	}
+
+	return viper.GetString("k8sProfileLabel")
+}
+
+// objectName return the name of the object written by Profiler for the profile
+func objectName(profileName string) string {
+	return "profiler-" + profileName
+}
+
+// profileObjects list the ConfigMaps and Secrets of the namespace labeled with
+// the given selector
+func profileObjects(client kubernetes.Interface, ns string, selector string) ([]corev1.ConfigMap, []corev1.Secret, error) {
+	options := metav1.ListOptions{LabelSelector: selector}
+
+	configMaps, err := client.CoreV1().ConfigMaps(ns).List(context.TODO(), options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secrets, err := client.CoreV1().Secrets(ns).List(context.TODO(), options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return configMaps.Items, secrets.Items, nil
+}
+
+func profileSelector(profileName string) string {
+	return profileLabel() + "=" + profileName
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	client, ns, err := connect()
+	if err != nil {
+		return false, err
+	}
+
+	configMaps, secrets, err := profileObjects(client, ns, profileSelector(profileName))
+	if err != nil {
+		return false, err
+	}
+
+	return len(configMaps)+len(secrets) > 0, nil
+}
+
+/*ListProfiles return the name of the Kubernetes profiles as []string*/
+func ListProfiles() ([]string, error) {
+	client, ns, err := connect()
+	if err != nil {
+		return []string{}, err
+	}
+
+	configMaps, secrets, err := profileObjects(client, ns, profileLabel())
+	if err != nil {
+		return []string{}, err
+	}
+
+	names := make(map[string]bool)
+	for _, c := range configMaps {
+		names[c.Labels[profileLabel()]] = true
+	}
+	for _, s := range secrets {
+		names[s.Labels[profileLabel()]] = true
+	}
+
+	var profiles []string
+	for name := range names {
+		if name != "" {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+/*GetProfile retrieve the given profile variables, merged from its ConfigMaps then its Secrets*/
+func GetProfile(profileName string) (map[string]string, error) {
+	client, ns, err := connect()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	configMaps, secrets, err := profileObjects(client, ns, profileSelector(profileName))
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	if len(configMaps)+len(secrets) == 0 {
+		return map[string]string{}, fmt.Errorf("profile %s not found", profileName)
+	}
+
+	vars := map[string]string{"profile_name": profileName}
+	for _, c := range configMaps {
+		for k, v := range c.Data {
+			vars[k] = v
+		}
+	}
+	// The API returns the Secrets values base64-decoded:
+	for _, s := range secrets {
+		for k, v := range s.Data {
+			vars[k] = string(v)
+		}
+	}
+
+	return vars, nil
+}
+
+/*ShowProfile return the list of keys for a profile*/
+func ShowProfile(profileName string) ([]string, error) {
+	vars, err := GetProfile(profileName)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// writeKind return the kind of object the new profiles are written as
+// (`k8sWriteKind`, Secrets by default)
+func writeKind() (string, error) {
+	switch viper.GetString("k8sWriteKind") {
+	case "", KindSecret:
+		return KindSecret, nil
+	case KindConfigMap:
+		return KindConfigMap, nil
+	default:
+		return "", fmt.Errorf("unsupported k8sWriteKind %s (expecting %s or %s)", viper.GetString("k8sWriteKind"), KindSecret, KindConfigMap)
+	}
+}
+
+/*AddKVPair add one or more KV pairs to the object written by Profiler for the profile*/
+func AddKVPair(profileName string, KVs []string) error {
+	if !viper.GetBool("k8sWritable") {
+		return ErrReadOnly
+	}
+
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	kind, err := writeKind()
+	if err != nil {
+		return err
+	}
+	client, ns, err := connect()
+	if err != nil {
+		return err
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      objectName(profileName),
+		Namespace: ns,
+		Labels:    map[string]string{profileLabel(): profileName},
+	}
+
+	if kind == KindConfigMap {
+		configMaps := client.CoreV1().ConfigMaps(ns)
+
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			c, err := configMaps.Get(context.TODO(), meta.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				c = &corev1.ConfigMap{ObjectMeta: meta, Data: make(map[string]string)}
+				for i := 0; i < len(KVs); i += 2 {
+					c.Data[KVs[i]] = KVs[i+1]
+				}
+				_, err = configMaps.Create(context.TODO(), c, metav1.CreateOptions{})
+				return err
+			}
+			if err != nil {
+				return err
+			}
+
+			if c.Data == nil {
+				c.Data = make(map[string]string)
+			}
+			for i := 0; i < len(KVs); i += 2 {
+				c.Data[KVs[i]] = KVs[i+1]
+			}
+			_, err = configMaps.Update(context.TODO(), c, metav1.UpdateOptions{})
+			return err
+		})
+	}
+
+	secrets := client.CoreV1().Secrets(ns)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		s, err := secrets.Get(context.TODO(), meta.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			s = &corev1.Secret{ObjectMeta: meta, Type: corev1.SecretTypeOpaque, Data: make(map[string][]byte)}
+			for i := 0; i < len(KVs); i += 2 {
+				s.Data[KVs[i]] = []byte(KVs[i+1])
+			}
+			_, err = secrets.Create(context.TODO(), s, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.Data == nil {
+			s.Data = make(map[string][]byte)
+		}
+		for i := 0; i < len(KVs); i += 2 {
+			s.Data[KVs[i]] = []byte(KVs[i+1])
+		}
+		_, err = secrets.Update(context.TODO(), s, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+/*RemoveKVPair remove the given variable from every object of the profile holding it*/
+func RemoveKVPair(profileName string, key string) error {
+	if !viper.GetBool("k8sWritable") {
+		return ErrReadOnly
+	}
+
+	client, ns, err := connect()
+	if err != nil {
+		return err
+	}
+
+	configMaps, secrets, err := profileObjects(client, ns, profileSelector(profileName))
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, c := range configMaps {
+		if _, ok := c.Data[key]; !ok {
+			continue
+		}
+		found = true
+
+		// The update fails on conflict rather than removing a variable from
+		// an object that changed:
+		delete(c.Data, key)
+		_, err = client.CoreV1().ConfigMaps(ns).Update(context.TODO(), &c, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range secrets {
+		if _, ok := s.Data[key]; !ok {
+			continue
+		}
+		found = true
+
+		delete(s.Data, key)
+		_, err = client.CoreV1().Secrets(ns).Update(context.TODO(), &s, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%s not found in the %s profile", key, profileName)
+	}
+
+	return nil
+}
+
+/*DeleteProfile delete every ConfigMap and Secret of the given profile*/
+func DeleteProfile(profileName string) error {
+	if !viper.GetBool("k8sWritable") {
+		return ErrReadOnly
+	}
+
+	client, ns, err := connect()
+	if err != nil {
+		return err
+	}
+
+	configMaps, secrets, err := profileObjects(client, ns, profileSelector(profileName))
+	if err != nil {
+		return err
+	}
+
+	for _, c := range configMaps {
+		err = client.CoreV1().ConfigMaps(ns).Delete(context.TODO(), c.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	for _, s := range secrets {
+		err = client.CoreV1().Secrets(ns).Delete(context.TODO(), s.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
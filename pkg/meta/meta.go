@@ -0,0 +1,83 @@
+package meta
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Key is the reserved profile key holding the profile metadata. It is never
+// exported as an environment variable.
+const Key = "_meta"
+
+// Meta describes a profile: what it is for, who owns it and how to find it.
+type Meta struct {
+	Description string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Owner       string            `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Tags        []string          `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Keys        map[string]string `yaml:"keys,omitempty" json:"keys,omitempty"`
+}
+
+// IsEmpty return true if no metadata has been set
+func (m Meta) IsEmpty() bool {
+	return m.Description == "" && m.Owner == "" && len(m.Tags) == 0 && len(m.Keys) == 0
+}
+
+// HasTags return true if the metadata contains every given tag
+func (m Meta) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range m.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddTags add the given tags to the metadata, ignoring the ones already set
+func (m *Meta) AddTags(tags []string) {
+	for _, tag := range tags {
+		if !m.HasTags([]string{tag}) {
+			m.Tags = append(m.Tags, tag)
+		}
+	}
+	sort.Strings(m.Tags)
+}
+
+// SetKeyDescription set the description of a single profile variable
+func (m *Meta) SetKeyDescription(key, description string) {
+	if m.Keys == nil {
+		m.Keys = make(map[string]string)
+	}
+	m.Keys[key] = description
+}
+
+// Encode return the metadata as a single line of JSON, for the backends
+// holding it in the Key variable of the profile
+func Encode(m Meta) (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// Decode return the metadata held by the Key variable of a profile, no
+// metadata when it is empty
+func Decode(value string) (Meta, error) {
+	var m Meta
+	if value == "" {
+		return m, nil
+	}
+
+	err := json.Unmarshal([]byte(value), &m)
+
+	return m, err
+}
@@ -0,0 +1,188 @@
+package pass
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const defaultPrefix = "profiler"
+
+// storeDir return the password store folder: `passwordStoreDir`, then
+// PASSWORD_STORE_DIR like pass, then ~/.password-store
+func storeDir() string {
+	if viper.GetString("passwordStoreDir") != "" {
+		return viper.GetString("passwordStoreDir")
+	}
+	if os.Getenv("PASSWORD_STORE_DIR") != "" {
+		return os.Getenv("PASSWORD_STORE_DIR")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".password-store"
+	}
+
+	return filepath.Join(home, ".password-store")
+}
+
+// prefix return the folder of the store holding the profiles (`passPrefix`)
+func prefix() string {
+	if viper.GetString("passPrefix") == "" {
+		return defaultPrefix
+	}
+
+	return strings.Trim(viper.GetString("passPrefix"), "/")
+}
+
+func profileEntry(profileName string) string {
+	return prefix() + "/" + profileName
+}
+
+/*Source describe where the pass profiles are read from: the password store and the profiles folder*/
+func Source() string {
+	return filepath.Join(storeDir(), prefix())
+}
+
+/*Configured tell if the password store holds a profiles folder*/
+func Configured() bool {
+	info, err := os.Stat(filepath.Join(storeDir(), prefix()))
+
+	return err == nil && info.IsDir()
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	info, err := os.Stat(filepath.Join(storeDir(), profileEntry(profileName)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return info.IsDir(), nil
+}
+
+/*ListProfiles return the name of the pass profiles as []string*/
+func ListProfiles() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(storeDir(), prefix()))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return []string{}, err
+	}
+
+	var profiles []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			profiles = append(profiles, e.Name())
+		}
+	}
+
+	return profiles, nil
+}
+
+/*ShowProfile return the list of keys for a profile, without decrypting them*/
+func ShowProfile(profileName string) ([]string, error) {
+	exist, err := ProfileExist(profileName)
+	if err != nil {
+		return []string{}, err
+	}
+	if !exist {
+		return []string{}, fmt.Errorf("profile %s not found", profileName)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(storeDir(), profileEntry(profileName)))
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".gpg") {
+			keys = append(keys, strings.TrimSuffix(e.Name(), ".gpg"))
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+/*GetProfile decrypt the given profile variables, the profile name being given by the folder*/
+func GetProfile(profileName string) (map[string]string, error) {
+	keys, err := ShowProfile(profileName)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	vars := map[string]string{"profile_name": profileName}
+	for _, k := range keys {
+		value, err := readEntry(profileEntry(profileName) + "/" + k)
+		if err != nil {
+			return map[string]string{}, err
+		}
+		vars[k] = value
+	}
+
+	return vars, nil
+}
+
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	for i := 0; i < len(KVs); i += 2 {
+		if strings.Contains(KVs[i], "/") {
+			return fmt.Errorf("invalid variable name %s", KVs[i])
+		}
+		// Only the first line of an entry is read back, as by pass:
+		if strings.Contains(KVs[i+1], "\n") {
+			return fmt.Errorf("the value of %s can't hold several lines", KVs[i])
+		}
+	}
+
+	// A profile without variable is an empty folder:
+	err := os.MkdirAll(filepath.Join(storeDir(), profileEntry(profileName)), 0700)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(KVs); i += 2 {
+		err := writeEntry(profileEntry(profileName)+"/"+KVs[i], KVs[i+1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	keys, err := ShowProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(keys, key)
+	if i == len(keys) || keys[i] != key {
+		return fmt.Errorf("%s not found in the %s profile", key, profileName)
+	}
+
+	return removeEntry(profileEntry(profileName)+"/"+key, false)
+}
+
+/*DeleteProfile delete the given profile folder and its entries*/
+func DeleteProfile(profileName string) error {
+	return removeEntry(profileEntry(profileName), true)
+}
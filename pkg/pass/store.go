@@ -0,0 +1,134 @@
+package pass
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// run execute the command with the given standard input and return its output
+func run(stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "PASSWORD_STORE_DIR="+storeDir())
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s %s: %s", name, args[0], msg)
+		}
+		return nil, fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+
+	return out, nil
+}
+
+// usePassCLI tell if the entries go through the pass CLI (which also commits
+// them when the store is a git repository) or directly through gpg
+func usePassCLI() bool {
+	if viper.GetBool("passUseGpg") {
+		return false
+	}
+
+	_, err := exec.LookPath("pass")
+
+	return err == nil
+}
+
+func entryFile(entry string) string {
+	return filepath.Join(storeDir(), entry+".gpg")
+}
+
+// readEntry return the first line of the decrypted entry, pass keeping the
+// password on the first line and optional notes on the following ones
+func readEntry(entry string) (string, error) {
+	var out []byte
+	var err error
+	if usePassCLI() {
+		out, err = run(nil, "pass", "show", entry)
+	} else {
+		out, err = run(nil, "gpg", "--quiet", "--batch", "--decrypt", entryFile(entry))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.SplitN(string(out), "\n", 2)[0], nil
+}
+
+func writeEntry(entry string, value string) error {
+	if usePassCLI() {
+		_, err := run([]byte(value+"\n"), "pass", "insert", "--multiline", "--force", entry)
+
+		return err
+	}
+
+	file := entryFile(entry)
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+
+	ids, err := recipients(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--quiet", "--batch", "--yes", "--no-encrypt-to", "--encrypt", "--output", file}
+	for _, id := range ids {
+		args = append(args, "--recipient", id)
+	}
+
+	_, err = run([]byte(value+"\n"), "gpg", args...)
+
+	return err
+}
+
+func removeEntry(entry string, recursive bool) error {
+	if usePassCLI() {
+		args := []string{"rm", "--force", entry}
+		if recursive {
+			args = []string{"rm", "--recursive", "--force", entry}
+		}
+		_, err := run(nil, "pass", args...)
+
+		return err
+	}
+
+	if recursive {
+		return os.RemoveAll(filepath.Join(storeDir(), entry))
+	}
+
+	return os.Remove(entryFile(entry))
+}
+
+// recipients return the GPG ids of the `.gpg-id` file the closest to the given
+// folder of the store, like pass does
+func recipients(dir string) ([]string, error) {
+	root := filepath.Clean(storeDir())
+
+	for {
+		b, err := ioutil.ReadFile(filepath.Join(dir, ".gpg-id"))
+		if err == nil {
+			return strings.Fields(string(b)), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if filepath.Clean(dir) == root || filepath.Dir(dir) == dir {
+			return nil, fmt.Errorf("no .gpg-id found in %s, please run `pass init <gpg-id>`", root)
+		}
+		dir = filepath.Dir(dir)
+	}
+}
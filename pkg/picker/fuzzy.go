@@ -0,0 +1,74 @@
+package picker
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// score return how well the query matches the given text as a subsequence.
+// A negative score means that the text does not match. Consecutive and word
+// start matches are rewarded so that `awsdev` prefers `aws_dev` to
+// `a_web_service_devtools`.
+func score(query, text string) int {
+	if query == "" {
+		return 0
+	}
+
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(text))
+
+	s := 0
+	qi := 0
+	previous := -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+
+		s++
+		if previous == ti-1 {
+			s += 2
+		}
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1]) {
+			s++
+		}
+
+		previous = ti
+		qi++
+	}
+
+	if qi < len(q) {
+		return -1
+	}
+
+	return s
+}
+
+// Filter return the items matching the query, best matches first
+func Filter(items []Item, query string) []Item {
+	type match struct {
+		item  Item
+		score int
+	}
+
+	var matches []match
+	for _, item := range items {
+		s := score(query, item.Name)
+		if s < 0 {
+			continue
+		}
+		matches = append(matches, match{item: item, score: s})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	filtered := make([]Item, 0, len(matches))
+	for _, m := range matches {
+		filtered = append(filtered, m.item)
+	}
+
+	return filtered
+}
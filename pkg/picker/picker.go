@@ -0,0 +1,224 @@
+package picker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	maxVisibleItems = 10
+	maxPreviewKeys  = 8
+)
+
+// ErrAborted is returned when the picker is left without selecting a profile
+var ErrAborted = errors.New("no profile selected")
+
+// Item is a profile displayed in the picker
+type Item struct {
+	Name        string
+	Backend     string
+	Description string
+}
+
+// Preview return the lines displayed under the list for the selected item
+type Preview func(Item) []string
+
+// IsInteractive return true if both stdin and stderr are attached to a terminal
+func IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+type picker struct {
+	items    []Item
+	matches  []Item
+	query    string
+	selected int
+	offset   int
+	preview  Preview
+	previews map[Item][]string
+	width    int
+}
+
+// Pick display an interactive fuzzy finder on the terminal and return the
+// selected item
+func Pick(items []Item, preview Preview) (Item, error) {
+	fd := int(os.Stdin.Fd())
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return Item{}, err
+	}
+	defer term.Restore(fd, state)
+
+	width, _, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+
+	p := &picker{
+		items:    items,
+		matches:  Filter(items, ""),
+		preview:  preview,
+		previews: make(map[Item][]string),
+		width:    width,
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		p.draw()
+
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			p.clear()
+			return Item{}, err
+		}
+
+		switch r {
+		case '\r', '\n':
+			p.clear()
+			if len(p.matches) == 0 {
+				return Item{}, ErrAborted
+			}
+			return p.matches[p.selected], nil
+		case 3, 4: // Ctrl-C, Ctrl-D
+			p.clear()
+			return Item{}, ErrAborted
+		case 16: // Ctrl-P
+			p.move(-1)
+		case 14: // Ctrl-N
+			p.move(1)
+		case 127, 8: // Backspace
+			if len(p.query) > 0 {
+				q := []rune(p.query)
+				p.setQuery(string(q[:len(q)-1]))
+			}
+		case 21: // Ctrl-U
+			p.setQuery("")
+		case 27: // Escape sequences (arrows) or Escape alone
+			if reader.Buffered() == 0 {
+				p.clear()
+				return Item{}, ErrAborted
+			}
+			seq := make([]byte, 2)
+			if _, err := reader.Read(seq); err != nil {
+				continue
+			}
+			switch string(seq) {
+			case "[A", "OA":
+				p.move(-1)
+			case "[B", "OB":
+				p.move(1)
+			}
+		default:
+			if r >= 32 {
+				p.setQuery(p.query + string(r))
+			}
+		}
+	}
+}
+
+func (p *picker) setQuery(query string) {
+	p.query = query
+	p.matches = Filter(p.items, query)
+	p.selected = 0
+	p.offset = 0
+}
+
+func (p *picker) move(delta int) {
+	if len(p.matches) == 0 {
+		return
+	}
+
+	p.selected += delta
+	if p.selected < 0 {
+		p.selected = 0
+	}
+	if p.selected >= len(p.matches) {
+		p.selected = len(p.matches) - 1
+	}
+
+	// Scroll the visible window to keep the selection displayed:
+	if p.selected < p.offset {
+		p.offset = p.selected
+	}
+	if p.selected >= p.offset+maxVisibleItems {
+		p.offset = p.selected - maxVisibleItems + 1
+	}
+}
+
+func (p *picker) lines() []string {
+	lines := []string{fmt.Sprintf("profile> %s", p.query)}
+
+	end := p.offset + maxVisibleItems
+	if end > len(p.matches) {
+		end = len(p.matches)
+	}
+
+	for i := p.offset; i < end; i++ {
+		item := p.matches[i]
+		cursor := "  "
+		if i == p.selected {
+			cursor = "> "
+		}
+		line := fmt.Sprintf("%s%-30s %-8s %s", cursor, item.Name, "["+item.Backend+"]", item.Description)
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("  %d/%d", len(p.matches), len(p.items)))
+
+	if len(p.matches) > 0 && p.preview != nil {
+		item := p.matches[p.selected]
+		keys, ok := p.previews[item]
+		if !ok {
+			keys = p.preview(item)
+			p.previews[item] = keys
+		}
+
+		for i, key := range keys {
+			if i == maxPreviewKeys {
+				lines = append(lines, fmt.Sprintf("    ... (%d more)", len(keys)-maxPreviewKeys))
+				break
+			}
+			lines = append(lines, "    - "+key)
+		}
+	}
+
+	// Avoid line wrapping which would break the redraw:
+	for i, line := range lines {
+		lines[i] = Truncate(line, p.width-1)
+	}
+
+	return lines
+}
+
+/*Truncate cut the line to its first width characters, without splitting multi-byte characters*/
+func Truncate(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) <= width {
+		return line
+	}
+
+	return string(runes[:width])
+}
+
+// clear erase the previously drawn picker, the cursor being on its first line
+func (p *picker) clear() {
+	fmt.Fprint(os.Stderr, "\r\x1b[J")
+}
+
+func (p *picker) draw() {
+	lines := p.lines()
+
+	p.clear()
+	fmt.Fprint(os.Stderr, strings.Join(lines, "\r\n"))
+
+	// Move the cursor back at the end of the query line:
+	if len(lines) > 1 {
+		fmt.Fprintf(os.Stderr, "\x1b[%dA", len(lines)-1)
+	}
+	fmt.Fprintf(os.Stderr, "\r\x1b[%dC", len([]rune(lines[0])))
+}
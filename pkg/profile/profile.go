@@ -2,6 +2,8 @@ package profile
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -13,7 +15,11 @@ import (
 
 	yaml "gopkg.in/yaml.v3"
 
-	"github.com/julienlevasseur/profiler/pkg/ssm"
+	"github.com/julienlevasseur/profiler/pkg/agent"
+	"github.com/julienlevasseur/profiler/pkg/backend"
+	"github.com/julienlevasseur/profiler/pkg/cache"
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/julienlevasseur/profiler/pkg/remote"
 	"github.com/spf13/viper"
 )
 
@@ -64,8 +70,8 @@ func AppendToFile(filePath, profileName, key, value string) error {
 		}
 	}
 
-	f, err := os.OpenFile(filePath,	os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
 	if newProfile {
 		_, err = f.WriteString(
 			fmt.Sprintf("profile_name: %s\n", profileName),
@@ -136,16 +142,51 @@ func RemoveFromFile(filePath, match string) error {
 	return nil
 }
 
+// parseYamlProfile split the given yaml document into its variables and its
+// optional `_meta` section
+func parseYamlProfile(source []byte) (KeyValueMap, meta.Meta, error) {
+	var nodes map[string]yaml.Node
+	var m meta.Meta
+
+	err := yaml.Unmarshal(source, &nodes)
+	if err != nil {
+		return nil, m, err
+	}
+
+	var y KeyValueMap
+	for k, node := range nodes {
+		if k == meta.Key {
+			err = node.Decode(&m)
+			if err != nil {
+				return nil, m, err
+			}
+			continue
+		}
+
+		if y == nil {
+			y = make(KeyValueMap)
+		}
+
+		var v string
+		err = node.Decode(&v)
+		if err != nil {
+			return nil, m, err
+		}
+		y[k] = v
+	}
+
+	return y, m, nil
+}
+
 // ParseYaml parse the given yaml file
 func ParseYaml(filename string) KeyValueMap {
-	var y KeyValueMap
 	source, err := ioutil.ReadFile((filename))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	err = yaml.Unmarshal(source, &y)
+	y, _, err := parseYamlProfile(source)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -154,6 +195,23 @@ func ParseYaml(filename string) KeyValueMap {
 	return y
 }
 
+// ParseMeta parse the `_meta` section of the given yaml file
+func ParseMeta(filename string) meta.Meta {
+	source, err := ioutil.ReadFile((filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	_, m, err := parseYamlProfile(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	return m
+}
+
 // ParseEnvrc parse the given rc file
 func ParseEnvrc(filename string) KeyValueMap {
 	envrcVars := make(map[string]string)
@@ -196,6 +254,10 @@ func SetEnvironment(yml KeyValueMap) {
 	}
 
 	for k, v := range yml {
+		// The profile metadata are never exported:
+		if k == meta.Key {
+			continue
+		}
 
 		file, err := os.OpenFile(profilerFile, os.O_APPEND|os.O_WRONLY, 0644)
 
@@ -211,27 +273,50 @@ func SetEnvironment(yml KeyValueMap) {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+	}
 
-		//if `k8sSwitchNamespace` is activated and the K8S_NAMESPACE env var is set in the profile, profiler will automatically switch namespace to this value.
-		if viper.GetBool("k8sSwitchNamespace") {
-			checkForKubernetesNamespace(k, v)
-		}
+	if !viper.GetBool("preserveProfile") {
+		err := os.Remove(".profiler")
 
-		os.Setenv(k, v)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
-	if err != nil {
+	execShell(yml)
+}
+
+// setSecretEnvironment set a new environment in the given shell like
+// SetEnvironment, for the profiles of the secret backends (pass, Vault,
+// Secrets Manager, Kubernetes, encrypted S3 profiles and SSM profiles holding
+// SecureString parameters): their variables are never written in the
+// profilerFile, which is removed rather than left with the variables of
+// another profile
+func setSecretEnvironment(yml KeyValueMap) {
+	err := os.Remove(profilerFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	if !viper.GetBool("preserveProfile") {
-		err := os.Remove(".profiler")
+	execShell(yml)
+}
 
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+// execShell export the variables and replace the current process with the
+// configured shell
+func execShell(yml KeyValueMap) {
+	for k, v := range yml {
+		if k == meta.Key {
+			continue
+		}
+
+		//if `k8sSwitchNamespace` is activated and the K8S_NAMESPACE env var is set in the profile, profiler will automatically switch namespace to this value.
+		if viper.GetBool("k8sSwitchNamespace") {
+			checkForKubernetesNamespace(k, v)
 		}
+
+		os.Setenv(k, v)
 	}
 
 	shell := viper.GetString("shell")
@@ -250,15 +335,34 @@ func SetEnvironment(yml KeyValueMap) {
 	}
 }
 
+// profileFile return the file of the given profile, either a local profile
+// or a `<remote>/<profile>` profile of a git remote
+func profileFile(profileFolder string, profileName string) string {
+	if remote.IsRemoteProfile(profileName) {
+		file, err := remote.ProfileFile(profileName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return file
+	}
+
+	return fmt.Sprintf(
+		"%s/.%v.yml",
+		profileFolder,
+		profileName,
+	)
+}
+
 // GetProfile retrieve the profile from yaml definition
 func GetProfile(profileFolder string, profileName string) KeyValueMap {
-	return ParseYaml(
-		fmt.Sprintf(
-			"%s/.%v.yml",
-			profileFolder,
-			profileName,
-		),
-	)
+	return ParseYaml(profileFile(profileFolder, profileName))
+}
+
+// GetMeta retrieve the profile metadata from yaml definition
+func GetMeta(profileFolder string, profileName string) meta.Meta {
+	return ParseMeta(profileFile(profileFolder, profileName))
 }
 
 // Use set the environment for the given profile
@@ -290,14 +394,68 @@ func Use(profilesFolder string, profileName string) {
 	SetEnvironment(envVars)
 }
 
-// UseSSMProfile set the environment for the given remote AWS SSM profile
-func UseSSMProfile(profileName string) {
-	vars, err := ssm.GetProfile(profileName)
+// cachedProfile return the variables of the given remote profile from the
+// agent when it holds them, otherwise fetch them and hand them to the agent,
+// unless they are a stale copy from the offline cache
+func cachedProfile(key string, get func() (map[string]string, bool, error)) (map[string]string, error) {
+	if vars, ok := agent.Get(key); ok {
+		return vars, nil
+	}
+
+	vars, stale, err := get()
+	if err != nil {
+		return vars, err
+	}
+	if !stale {
+		agent.Put(key, vars)
+	}
+
+	return vars, nil
+}
+
+// agentKey return the key of the profile in the agent,
+// `<backend>/<profile>[@<version>]@<source>`, the source being hashed so that
+// the profiles of two configurations of a backend (e.g. two regions) are kept
+// apart
+func agentKey(r backend.Registration, profileName string) string {
+	key := r.Name + "/" + profileName
+	if r.Version != nil {
+		key += "@" + r.Version()
+	}
+
+	var source string
+	if r.Source != nil {
+		source = r.Source()
+	}
+	sum := sha256.Sum256([]byte(source))
+
+	return key + "@" + hex.EncodeToString(sum[:6])
+}
+
+// UseBackend set the environment for the given profile of a backend. The
+// profile is handed to the agent once fetched, read from the offline cache
+// when the backend keeps one and is unreachable, and never written on disk
+// when it holds secrets.
+func UseBackend(r backend.Registration, profileName string) {
+	vars, err := cachedProfile(agentKey(r, profileName), func() (map[string]string, bool, error) {
+		if r.Offline {
+			return cache.Fetch(r.Name, profileName, r.Source(), r.GetProfile)
+		}
+
+		vars, err := r.GetProfile(profileName)
+
+		return vars, false, err
+	})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
+	if r.IsSecret(profileName) {
+		setSecretEnvironment(vars)
+		return
+	}
+
 	SetEnvironment(vars)
 }
 
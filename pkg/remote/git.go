@@ -0,0 +1,34 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// git run the git command in the given directory and return its output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// refExists tell if the given reference exists in the repository
+func refExists(dir string, ref string) bool {
+	_, err := git(dir, "rev-parse", "--verify", "--quiet", ref)
+
+	return err == nil
+}
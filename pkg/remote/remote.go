@@ -0,0 +1,344 @@
+package remote
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/julienlevasseur/profiler/pkg/yamlfile"
+)
+
+const defaultBranchPrefix = "profiler/"
+
+// validName match the remote and profile names, used as folder and file
+// names, keeping them within the remotes folder
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// profileFileNames are the names a profile file can have in a remote
+// repository, in order of preference
+var profileFileNames = []string{"%s.yml", "%s.yaml", ".%s.yml", ".%s.yaml"}
+
+/*Remote is a git repository of shared profiles*/
+type Remote struct {
+	Name string
+	URL  string
+}
+
+// folder return the folder the remotes are cloned into (`remotesFolder`)
+func folder() string {
+	if viper.GetString("remotesFolder") != "" {
+		return viper.GetString("remotesFolder")
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+
+	return filepath.Join(cacheDir, "profiler", "remotes")
+}
+
+func remoteDir(remoteName string) string {
+	return filepath.Join(folder(), remoteName)
+}
+
+func exists(remoteName string) bool {
+	if !validName.MatchString(remoteName) {
+		return false
+	}
+
+	info, err := os.Stat(remoteDir(remoteName))
+
+	return err == nil && info.IsDir()
+}
+
+// splitProfileName split a `<remote>/<profile>` name
+func splitProfileName(profileName string) (string, string, error) {
+	parts := strings.SplitN(profileName, "/", 2)
+	if len(parts) < 2 || !exists(parts[0]) {
+		return "", "", fmt.Errorf("%s is not a remote profile", profileName)
+	}
+	if !validName.MatchString(parts[1]) {
+		return "", "", fmt.Errorf("invalid profile name %s", profileName)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+/*Add clone the git repository of the given URL as a new remote*/
+func Add(remoteName string, url string) error {
+	if !validName.MatchString(remoteName) {
+		return fmt.Errorf("invalid remote name %s", remoteName)
+	}
+
+	if exists(remoteName) {
+		return fmt.Errorf("the %s remote already exists", remoteName)
+	}
+
+	err := os.MkdirAll(folder(), 0755)
+	if err != nil {
+		return err
+	}
+
+	_, err = git(folder(), "clone", "--quiet", url, remoteName)
+
+	return err
+}
+
+/*Remove delete the clone of the given remote*/
+func Remove(remoteName string) error {
+	if !exists(remoteName) {
+		return fmt.Errorf("the %s remote doesn't exist", remoteName)
+	}
+
+	return os.RemoveAll(remoteDir(remoteName))
+}
+
+/*List return the configured remotes*/
+func List() ([]Remote, error) {
+	entries, err := ioutil.ReadDir(folder())
+	if os.IsNotExist(err) {
+		return []Remote{}, nil
+	}
+	if err != nil {
+		return []Remote{}, err
+	}
+
+	var remotes []Remote
+	for _, e := range entries {
+		if !e.IsDir() || !validName.MatchString(e.Name()) {
+			continue
+		}
+
+		url, err := git(remoteDir(e.Name()), "remote", "get-url", "origin")
+		if err != nil {
+			return []Remote{}, err
+		}
+
+		remotes = append(remotes, Remote{Name: e.Name(), URL: url})
+	}
+
+	return remotes, nil
+}
+
+/*Update pull the latest profiles of the given remote (every remote when empty)*/
+func Update(remoteName string) error {
+	var names []string
+	if remoteName != "" {
+		if !exists(remoteName) {
+			return fmt.Errorf("the %s remote doesn't exist", remoteName)
+		}
+		names = append(names, remoteName)
+	} else {
+		remotes, err := List()
+		if err != nil {
+			return err
+		}
+		for _, r := range remotes {
+			names = append(names, r.Name)
+		}
+	}
+
+	for _, name := range names {
+		_, err := git(remoteDir(name), "pull", "--quiet", "--ff-only")
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+/*IsRemoteProfile tell if the profile name is a `<remote>/<profile>` name of a configured remote*/
+func IsRemoteProfile(profileName string) bool {
+	_, _, err := splitProfileName(profileName)
+
+	return err == nil
+}
+
+// findProfileFile return the name of the profile file in dir, empty if the
+// profile doesn't exist
+func findProfileFile(dir string, name string) string {
+	for _, pattern := range profileFileNames {
+		file := fmt.Sprintf(pattern, name)
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return file
+		}
+	}
+
+	return ""
+}
+
+/*ProfileFile return the path of the file of the given `<remote>/<profile>` profile*/
+func ProfileFile(profileName string) (string, error) {
+	remoteName, name, err := splitProfileName(profileName)
+	if err != nil {
+		return "", err
+	}
+
+	file := findProfileFile(remoteDir(remoteName), name)
+	if file == "" {
+		return "", fmt.Errorf("profile %s not found in the %s remote", name, remoteName)
+	}
+
+	return filepath.Join(remoteDir(remoteName), file), nil
+}
+
+/*ListProfiles return the profiles of every remote, as `<remote>/<profile>`*/
+func ListProfiles() ([]string, error) {
+	remotes, err := List()
+	if err != nil {
+		return []string{}, err
+	}
+
+	var profiles []string
+	for _, r := range remotes {
+		files, err := ioutil.ReadDir(remoteDir(r.Name))
+		if err != nil {
+			return []string{}, err
+		}
+
+		listed := make(map[string]bool)
+		for _, f := range files {
+			name := strings.TrimPrefix(f.Name(), ".")
+			ext := filepath.Ext(name)
+			if f.IsDir() || (ext != ".yml" && ext != ".yaml") {
+				continue
+			}
+
+			name = strings.TrimSuffix(name, ext)
+			if !listed[name] {
+				listed[name] = true
+				profiles = append(profiles, r.Name+"/"+name)
+			}
+		}
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+// branchName return the branch the changes of the profile are committed on
+// (`remoteBranchPrefix` followed by the profile name)
+func branchName(name string) string {
+	prefix := defaultBranchPrefix
+	if viper.IsSet("remoteBranchPrefix") {
+		prefix = viper.GetString("remoteBranchPrefix")
+	}
+
+	return prefix + name
+}
+
+// write apply fn to the profile file in a worktree of the profile branch,
+// then commit the change with the given message and push the branch so that
+// it can be reviewed. It returns the branch name.
+func write(profileName string, message string, fn func(file string) error) (string, error) {
+	remoteName, name, err := splitProfileName(profileName)
+	if err != nil {
+		return "", err
+	}
+
+	dir := remoteDir(remoteName)
+	branch := branchName(name)
+
+	_, err = git(dir, "fetch", "--quiet", "origin")
+	if err != nil {
+		return "", err
+	}
+
+	worktree, err := ioutil.TempDir("", "profiler-remote")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(worktree)
+
+	// Keep adding to the branch when it already exists, start from the
+	// up to date default branch otherwise:
+	args := []string{"worktree", "add", "--quiet"}
+	switch {
+	case refExists(dir, "refs/heads/"+branch):
+		args = append(args, worktree, branch)
+	case refExists(dir, "refs/remotes/origin/"+branch):
+		args = append(args, "-b", branch, worktree, "origin/"+branch)
+	case refExists(dir, "refs/remotes/origin/HEAD"):
+		args = append(args, "-b", branch, worktree, "origin/HEAD")
+	default:
+		args = append(args, "-b", branch, worktree, "HEAD")
+	}
+
+	_, err = git(dir, args...)
+	if err != nil {
+		return "", err
+	}
+	defer git(dir, "worktree", "remove", "--force", worktree)
+
+	file := findProfileFile(worktree, name)
+	if file == "" {
+		file = name + ".yml"
+	}
+
+	err = fn(filepath.Join(worktree, file))
+	if err != nil {
+		return "", err
+	}
+
+	for _, args := range [][]string{
+		{"add", "--", file},
+		{"commit", "--quiet", "-m", message},
+		{"push", "--quiet", "origin", branch},
+	} {
+		_, err = git(worktree, args...)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return branch, nil
+}
+
+/*SetVariable set the variable of the remote profile (created if needed) on the profile branch, returning the branch*/
+func SetVariable(profileName string, key string, value string) (string, error) {
+	_, name, err := splitProfileName(profileName)
+	if err != nil {
+		return "", err
+	}
+
+	return write(profileName, fmt.Sprintf("Set %s in the %s profile", key, name), func(file string) error {
+		return yamlfile.SetVariable(file, name, key, value)
+	})
+}
+
+/*RemoveVariable remove the variable from the remote profile on the profile branch, returning the branch*/
+func RemoveVariable(profileName string, key string) (string, error) {
+	_, name, err := splitProfileName(profileName)
+	if err != nil {
+		return "", err
+	}
+
+	return write(profileName, fmt.Sprintf("Remove %s from the %s profile", key, name), func(file string) error {
+		return yamlfile.RemoveVariable(file, name, key)
+	})
+}
+
+/*DeleteProfile delete the remote profile on the profile branch, returning the branch*/
+func DeleteProfile(profileName string) (string, error) {
+	_, name, err := splitProfileName(profileName)
+	if err != nil {
+		return "", err
+	}
+
+	return write(profileName, fmt.Sprintf("Delete the %s profile", name), func(file string) error {
+		err := os.Remove(file)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("profile %s not found", profileName)
+		}
+
+		return err
+	})
+}
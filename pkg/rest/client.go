@@ -0,0 +1,298 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/julienlevasseur/profiler/pkg/meta"
+)
+
+const requestTimeout = 30 * time.Second
+
+// errNotFound is returned by the requests answered with a 404
+var errNotFound = errors.New("not found")
+
+// client is the client of a `profiler serve` server
+type client struct {
+	address string
+	token   string
+	http    *http.Client
+}
+
+func newHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: viper.GetBool("httpSkipVerify"),
+	}
+
+	if caCert := viper.GetString("httpCACert"); caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
+	}, nil
+}
+
+/*Source describe where the profiles are read from: the address of the profiler server*/
+func Source() string {
+	return strings.TrimSuffix(viper.GetString("httpAddress"), "/")
+}
+
+// newClient build the client from `httpAddress` and the token of
+// `httpToken` or `httpTokenFile`
+func newClient() (*client, error) {
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		address: strings.TrimSuffix(viper.GetString("httpAddress"), "/"),
+		token:   viper.GetString("httpToken"),
+		http:    httpClient,
+	}
+	if c.address == "" {
+		return nil, errors.New("the address of the profiler server (httpAddress) is not configured")
+	}
+
+	if tokenFile := viper.GetString("httpTokenFile"); c.token == "" && tokenFile != "" {
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		c.token = strings.TrimSpace(string(b))
+	}
+
+	return c, nil
+}
+
+// request call the server API, encoding body and decoding the response in out
+// when they are set
+func (c *client) request(method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.address+apiPrefix+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr errorResponse
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", errNotFound, apiErr.Error)
+		}
+
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    apiErr.Error,
+		}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+/*APIError is an error returned by the profiler server*/
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("profiler server: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("profiler server: %d %s", e.StatusCode, e.Message)
+}
+
+func profilePath(profileName string) string {
+	return "/" + url.PathEscape(profileName)
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	c, err := newClient()
+	if err != nil {
+		return false, err
+	}
+
+	err = c.request(http.MethodGet, profilePath(profileName), nil, nil)
+	if errors.Is(err, errNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+/*ListProfiles return the name of the profiles of the server readable with the token*/
+func ListProfiles() ([]string, error) {
+	c, err := newClient()
+	if err != nil {
+		return []string{}, err
+	}
+
+	var resp listResponse
+	err = c.request(http.MethodGet, "", nil, &resp)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return resp.Profiles, nil
+}
+
+// getProfile return the profile as served
+func getProfile(profileName string) (profileResponse, error) {
+	var resp profileResponse
+
+	c, err := newClient()
+	if err != nil {
+		return resp, err
+	}
+
+	err = c.request(http.MethodGet, profilePath(profileName), nil, &resp)
+	if errors.Is(err, errNotFound) {
+		return resp, fmt.Errorf("profile %s not found", profileName)
+	}
+
+	return resp, err
+}
+
+/*GetProfile retrieve the given profile variables from the server*/
+func GetProfile(profileName string) (map[string]string, error) {
+	resp, err := getProfile(profileName)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return resp.Variables, nil
+}
+
+/*GetMeta return the metadata of the given profile, set in its file on the server*/
+func GetMeta(profileName string) (meta.Meta, error) {
+	resp, err := getProfile(profileName)
+	if err != nil || resp.Meta == nil {
+		return meta.Meta{}, err
+	}
+
+	return *resp.Meta, nil
+}
+
+/*ShowProfile return the list of keys for a profile*/
+func ShowProfile(profileName string) ([]string, error) {
+	vars, err := GetProfile(profileName)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	vars := make(map[string]string)
+	for i := 0; i < len(KVs); i += 2 {
+		vars[KVs[i]] = KVs[i+1]
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	return c.request(http.MethodPut, profilePath(profileName), profileResponse{Variables: vars}, nil)
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	err = c.request(http.MethodDelete, profilePath(profileName)+"/variables/"+url.PathEscape(key), nil, nil)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("%s not found in the %s profile", key, profileName)
+	}
+
+	return err
+}
+
+/*DeleteProfile delete the given profile from the server*/
+func DeleteProfile(profileName string) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	err = c.request(http.MethodDelete, profilePath(profileName), nil, nil)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("profile %s not found", profileName)
+	}
+
+	return err
+}
@@ -0,0 +1,365 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/julienlevasseur/profiler/pkg/meta"
+	"github.com/julienlevasseur/profiler/pkg/yamlfile"
+)
+
+const apiPrefix = "/v1/profiles"
+
+// validName match the profile names and variable names accepted by the
+// server, keeping the requests within the profiles folder
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+/*Token is an API token of the server and the profiles it grants access to*/
+type Token struct {
+	Name string `mapstructure:"name"`
+	// Token is the bearer token, or TokenSha256 its hex encoded SHA-256 hash
+	// to not keep it in clear in the configuration
+	Token       string `mapstructure:"token"`
+	TokenSha256 string `mapstructure:"tokenSha256"`
+	// Read and Write are the patterns (e.g. `dev`, `team-*` or `*`) of the
+	// profiles the token can read and write, writing implying reading
+	Read  []string `mapstructure:"read"`
+	Write []string `mapstructure:"write"`
+}
+
+func (t Token) matches(bearer string) bool {
+	if t.TokenSha256 != "" {
+		sum := sha256.Sum256([]byte(bearer))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(t.TokenSha256))) == 1
+	}
+
+	return t.Token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(t.Token)) == 1
+}
+
+func allowed(patterns []string, profileName string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, profileName); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t Token) canRead(profileName string) bool {
+	return allowed(t.Read, profileName) || t.canWrite(profileName)
+}
+
+func (t Token) canWrite(profileName string) bool {
+	return allowed(t.Write, profileName)
+}
+
+/*Server serve the profiles of a local profiles folder over the REST API*/
+type Server struct {
+	ProfilesFolder string
+	Tokens         []Token
+
+	// mutex serialize the writes of the profile files
+	mutex sync.Mutex
+}
+
+// profileFile return the existing file of the profile, or the file of a new
+// one, like the local profiles (.<profile>.yml or .<profile>.yaml)
+func (s *Server) profileFile(profileName string) (string, bool) {
+	for _, ext := range []string{".yml", ".yaml"} {
+		file := filepath.Join(s.ProfilesFolder, "."+profileName+ext)
+		if _, err := os.Stat(file); err == nil {
+			return file, true
+		}
+	}
+
+	return filepath.Join(s.ProfilesFolder, "."+profileName+".yml"), false
+}
+
+func (s *Server) profiles() ([]string, error) {
+	var profiles []string
+	for _, pattern := range []string{".*.yml", ".*.yaml"} {
+		files, err := filepath.Glob(filepath.Join(s.ProfilesFolder, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			name := strings.TrimPrefix(filepath.Base(file), ".")
+			profiles = append(profiles, strings.TrimSuffix(name, filepath.Ext(name)))
+		}
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+// errorResponse is the body of the error responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, errorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// statusRecorder keep the status of the response for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	token, ok := s.authenticate(r)
+	s.serve(recorder, r, token, ok)
+
+	log.Printf("%s %s %s %d", token.Name, r.Method, r.URL.Path, recorder.status)
+}
+
+func (s *Server) authenticate(r *http.Request) (Token, bool) {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if bearer == "" || bearer == r.Header.Get("Authorization") {
+		return Token{Name: "-"}, false
+	}
+
+	for _, t := range s.Tokens {
+		if t.matches(bearer) {
+			return t, true
+		}
+	}
+
+	return Token{Name: "-"}, false
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, token Token, authenticated bool) {
+	if !authenticated {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="profiler"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	if r.URL.Path == apiPrefix {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+			return
+		}
+		s.list(w, token)
+		return
+	}
+
+	// /v1/profiles/<profile> or /v1/profiles/<profile>/variables/<key>:
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, apiPrefix+"/"), "/")
+	if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") || (len(parts) != 1 && (len(parts) != 3 || parts[1] != "variables")) {
+		writeError(w, http.StatusNotFound, "%s not found", r.URL.Path)
+		return
+	}
+
+	profileName := parts[0]
+	if !validName.MatchString(profileName) {
+		writeError(w, http.StatusBadRequest, "invalid profile name %s", profileName)
+		return
+	}
+
+	write := r.Method != http.MethodGet
+	if (write && !token.canWrite(profileName)) || (!write && !token.canRead(profileName)) {
+		writeError(w, http.StatusForbidden, "access to the %s profile denied", profileName)
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		s.removeVariable(w, profileName, parts[2])
+	case len(parts) == 3:
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	case r.Method == http.MethodGet:
+		s.get(w, profileName)
+	case r.Method == http.MethodPut:
+		s.put(w, r, profileName)
+	case r.Method == http.MethodDelete:
+		s.delete(w, profileName)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// listResponse is the body of GET /v1/profiles
+type listResponse struct {
+	Profiles []string `json:"profiles"`
+}
+
+// profileResponse is the body of GET /v1/profiles/<profile>, and of the
+// PUT requests. The metadata is only served, it is set in the profile files.
+type profileResponse struct {
+	Variables map[string]string `json:"variables"`
+	Meta      *meta.Meta        `json:"meta,omitempty"`
+}
+
+func (s *Server) list(w http.ResponseWriter, token Token) {
+	profiles, err := s.profiles()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	// Only the profiles the token can read are listed:
+	readable := []string{}
+	for _, p := range profiles {
+		if token.canRead(p) {
+			readable = append(readable, p)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{Profiles: readable})
+}
+
+func (s *Server) get(w http.ResponseWriter, profileName string) {
+	file, exists := s.profileFile(profileName)
+	if !exists {
+		writeError(w, http.StatusNotFound, "profile %s not found", profileName)
+		return
+	}
+
+	vars, err := yamlfile.Variables(file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	m, err := yamlfile.Meta(file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	resp := profileResponse{Variables: vars}
+	if !m.IsEmpty() {
+		resp.Meta = &m
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// put set the given variables of the profile, created if needed
+func (s *Server) put(w http.ResponseWriter, r *http.Request, profileName string) {
+	var body profileResponse
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %s", err)
+		return
+	}
+
+	var keys []string
+	for k := range body.Variables {
+		if !validName.MatchString(k) || k == meta.Key {
+			writeError(w, http.StatusBadRequest, "invalid variable name %s", k)
+			return
+		}
+		keys = append(keys, k)
+	}
+	// Keep the order of the new variables stable in the file:
+	sort.Strings(keys)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, exists := s.profileFile(profileName)
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+		// An empty profile only has its name:
+		err = yamlfile.SetVariable(file, profileName, "profile_name", profileName)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+	}
+
+	for _, k := range keys {
+		err = yamlfile.SetVariable(file, profileName, k, body.Variables[k])
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+	}
+
+	vars, err := yamlfile.Variables(file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	writeJSON(w, status, profileResponse{Variables: vars})
+}
+
+func (s *Server) removeVariable(w http.ResponseWriter, profileName string, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, exists := s.profileFile(profileName)
+	if !exists {
+		writeError(w, http.StatusNotFound, "profile %s not found", profileName)
+		return
+	}
+
+	vars, err := yamlfile.Variables(file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	if _, ok := vars[key]; !ok {
+		writeError(w, http.StatusNotFound, "%s not found in the %s profile", key, profileName)
+		return
+	}
+
+	err = yamlfile.RemoveVariable(file, profileName, key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) delete(w http.ResponseWriter, profileName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, exists := s.profileFile(profileName)
+	if !exists {
+		writeError(w, http.StatusNotFound, "profile %s not found", profileName)
+		return
+	}
+
+	err := os.Remove(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
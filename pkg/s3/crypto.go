@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// clientSideEncryption is the value of the encryption metadata of the
+// objects encrypted by Profiler before upload
+const clientSideEncryption = "aes-256-gcm"
+
+// encryptionKey return the client-side encryption key, a base64 encoded
+// 32 bytes key read from `s3EncryptionKey` or `s3EncryptionKeyFile`, nil when
+// the client-side encryption isn't configured
+func encryptionKey() ([]byte, error) {
+	encoded := viper.GetString("s3EncryptionKey")
+	if viper.GetString("s3EncryptionKeyFile") != "" {
+		b, err := ioutil.ReadFile(viper.GetString("s3EncryptionKeyFile"))
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid S3 encryption key: expecting 32 bytes, got %d", len(key))
+	}
+
+	return key, nil
+}
+
+/*Encrypted tell if the profiles are encrypted client-side, `s3EncryptionKey` or `s3EncryptionKeyFile` being set*/
+func Encrypted() bool {
+	return viper.GetString("s3EncryptionKey") != "" || viper.GetString("s3EncryptionKeyFile") != ""
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt return the nonce followed by the sealed plaintext
+func encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("the encrypted profile is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt the profile (wrong S3 encryption key?): %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,354 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	defaultKeyPrefix = "profiler/"
+	profileExtension = ".yml"
+
+	// encryptionMetadata is the object metadata telling how the profile has
+	// been encrypted client-side
+	encryptionMetadata = "Profiler-Encryption"
+
+	maxCASAttempts = 5
+	casRetryDelay  = 100 * time.Millisecond
+)
+
+/*ErrConflict is returned when a profile keeps being modified concurrently*/
+var ErrConflict = errors.New("the profile has been concurrently modified, please retry")
+
+// service is the S3 client used by the package, when set it replaces the
+// default client built from the configuration
+var service s3iface.S3API
+
+/*SetService override the S3 client used by the package (nil restore the default one)*/
+func SetService(svc s3iface.S3API) {
+	service = svc
+}
+
+func newS3Service() s3iface.S3API {
+	if service != nil {
+		return service
+	}
+
+	mySession, err := newAWSSession()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	return s3.New(mySession)
+}
+
+func bucket() string {
+	return viper.GetString("s3Bucket")
+}
+
+// keyPrefix return the prefix of the profiles objects (`s3Prefix`)
+func keyPrefix() string {
+	if viper.GetString("s3Prefix") == "" {
+		return defaultKeyPrefix
+	}
+
+	return strings.TrimPrefix(strings.TrimSuffix(viper.GetString("s3Prefix"), "/")+"/", "/")
+}
+
+func objectKey(profileName string) string {
+	return keyPrefix() + profileName + profileExtension
+}
+
+// storedProfile is a profile object with the ETag it has been read with
+type storedProfile struct {
+	vars   map[string]string
+	etag   string
+	exists bool
+}
+
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	return aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+}
+
+// isConditionFailed tell if a conditional write has been refused because the
+// object changed since it has been read
+func isConditionFailed(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	return aerr.Code() == "PreconditionFailed" || aerr.Code() == "ConditionalRequestConflict"
+}
+
+func readProfile(svc s3iface.S3API, profileName string) (storedProfile, error) {
+	p := storedProfile{vars: make(map[string]string)}
+
+	output, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket()),
+		Key:    aws.String(objectKey(profileName)),
+	})
+	if isNotFound(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	defer output.Body.Close()
+
+	body, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return p, err
+	}
+
+	for k, v := range output.Metadata {
+		if !strings.EqualFold(k, encryptionMetadata) {
+			continue
+		}
+
+		if aws.StringValue(v) != clientSideEncryption {
+			return p, fmt.Errorf("unsupported encryption %s of the %s profile", aws.StringValue(v), profileName)
+		}
+
+		key, err := encryptionKey()
+		if err != nil {
+			return p, err
+		}
+		if key == nil {
+			return p, fmt.Errorf("the %s profile is encrypted, please configure s3EncryptionKey or s3EncryptionKeyFile", profileName)
+		}
+
+		body, err = decrypt(key, body)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	err = yaml.Unmarshal(body, &p.vars)
+	if err != nil {
+		return p, err
+	}
+
+	p.etag = aws.StringValue(output.ETag)
+	p.exists = true
+
+	return p, nil
+}
+
+// writeProfile upload the profile only if it hasn't changed since it has been
+// read: matching the read ETag, or not existing for a new profile
+func writeProfile(svc s3iface.S3API, profileName string, p storedProfile, vars map[string]string) error {
+	body, err := yaml.Marshal(vars)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket()),
+		Key:    aws.String(objectKey(profileName)),
+	}
+
+	key, err := encryptionKey()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		body, err = encrypt(key, body)
+		if err != nil {
+			return err
+		}
+		input.SetMetadata(map[string]*string{encryptionMetadata: aws.String(clientSideEncryption)})
+	}
+	input.SetBody(bytes.NewReader(body))
+
+	switch viper.GetString("s3ServerSideEncryption") {
+	case "":
+	case s3.ServerSideEncryptionAwsKms:
+		input.SetServerSideEncryption(s3.ServerSideEncryptionAwsKms)
+		if viper.GetString("s3KmsKeyId") != "" {
+			input.SetSSEKMSKeyId(viper.GetString("s3KmsKeyId"))
+		}
+	default:
+		input.SetServerSideEncryption(viper.GetString("s3ServerSideEncryption"))
+	}
+
+	// The SDK doesn't model the conditional writes, the headers are set on
+	// the request:
+	condition := map[string]string{"If-None-Match": "*"}
+	if p.exists {
+		condition = map[string]string{"If-Match": p.etag}
+	}
+
+	_, err = svc.PutObjectWithContext(aws.BackgroundContext(), input, request.WithSetRequestHeaders(condition))
+
+	return err
+}
+
+// updateProfile apply fn to the variables of the profile (created if it
+// doesn't exist yet) and write them back conditioned on the ETag read,
+// retrying when the profile has been concurrently modified
+func updateProfile(profileName string, fn func(vars map[string]string) error) error {
+	svc := newS3Service()
+
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * casRetryDelay)
+		}
+
+		p, err := readProfile(svc, profileName)
+		if err != nil {
+			return err
+		}
+
+		vars := make(map[string]string)
+		for k, v := range p.vars {
+			vars[k] = v
+		}
+		if !p.exists {
+			vars["profile_name"] = profileName
+		}
+
+		err = fn(vars)
+		if err != nil {
+			return err
+		}
+
+		err = writeProfile(svc, profileName, p, vars)
+		if isConditionFailed(err) {
+			continue
+		}
+
+		return err
+	}
+
+	return ErrConflict
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	_, err := newS3Service().HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket()),
+		Key:    aws.String(objectKey(profileName)),
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+/*ListProfiles return the name of the S3 profiles as []string*/
+func ListProfiles() ([]string, error) {
+	var profiles []string
+
+	err := newS3Service().ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket()),
+		Prefix: aws.String(keyPrefix()),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(o.Key), keyPrefix())
+			if strings.HasSuffix(name, profileExtension) {
+				profiles = append(profiles, strings.TrimSuffix(name, profileExtension))
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+/*GetProfile retrieve the given profile variables from S3*/
+func GetProfile(profileName string) (map[string]string, error) {
+	p, err := readProfile(newS3Service(), profileName)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	if !p.exists {
+		return map[string]string{}, fmt.Errorf("profile %s not found", profileName)
+	}
+
+	return p.vars, nil
+}
+
+/*ShowProfile return the list of keys for a profile*/
+func ShowProfile(profileName string) ([]string, error) {
+	vars, err := GetProfile(profileName)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	return updateProfile(profileName, func(vars map[string]string) error {
+		for i := 0; i < len(KVs); i += 2 {
+			vars[KVs[i]] = KVs[i+1]
+		}
+
+		return nil
+	})
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	return updateProfile(profileName, func(vars map[string]string) error {
+		if _, ok := vars[key]; !ok {
+			return fmt.Errorf("%s not found in the %s profile", key, profileName)
+		}
+
+		delete(vars, key)
+
+		return nil
+	})
+}
+
+/*DeleteProfile delete the given profile object*/
+func DeleteProfile(profileName string) error {
+	_, err := newS3Service().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket()),
+		Key:    aws.String(objectKey(profileName)),
+	})
+
+	return err
+}
@@ -0,0 +1,242 @@
+// Package s3fake provides an in-memory S3 bucket store implementing the subset
+// of s3iface.S3API used by Profiler, to test the S3 backend offline.
+package s3fake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Object is a stored object
+type Object struct {
+	Body                 []byte
+	ETag                 string
+	Metadata             map[string]string
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+}
+
+// S3 is an in-memory S3 store. Calling an operation it doesn't implement
+// panics.
+type S3 struct {
+	s3iface.S3API
+
+	mutex    sync.Mutex
+	objects  map[string]*Object
+	failures map[string][]error
+
+	// Calls count the calls of each operation
+	Calls map[string]int
+}
+
+// New return an empty store
+func New() *S3 {
+	return &S3{
+		objects:  make(map[string]*Object),
+		failures: make(map[string][]error),
+		Calls:    make(map[string]int),
+	}
+}
+
+// FailNext make the next calls of the given operation (e.g. "PutObject")
+// return the given errors, one per call
+func (s *S3) FailNext(operation string, errs ...error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures[operation] = append(s.failures[operation], errs...)
+}
+
+// call record the call of the operation and return the error it has to fail
+// with, the caller holding the lock
+func (s *S3) call(operation string) error {
+	s.Calls[operation]++
+
+	if errs := s.failures[operation]; len(errs) > 0 {
+		s.failures[operation] = errs[1:]
+		return errs[0]
+	}
+
+	return nil
+}
+
+func path(bucket *string, key *string) string {
+	return aws.StringValue(bucket) + "/" + aws.StringValue(key)
+}
+
+func etag(body []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(body)))
+}
+
+// Put store an object, bypassing the conditional writes
+func (s *S3) Put(bucket string, key string, body []byte, metadata map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[bucket+"/"+key] = &Object{Body: body, ETag: etag(body), Metadata: metadata}
+}
+
+// Get return a copy of the stored object, nil when it doesn't exist
+func (s *S3) Get(bucket string, key string) *Object {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil
+	}
+	c := *o
+
+	return &c
+}
+
+func noSuchKey() error {
+	return awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+}
+
+// GetObject return the object body and metadata
+func (s *S3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("GetObject"); err != nil {
+		return nil, err
+	}
+
+	o, ok := s.objects[path(input.Bucket, input.Key)]
+	if !ok {
+		return nil, noSuchKey()
+	}
+
+	return &s3.GetObjectOutput{
+		Body:     ioutil.NopCloser(bytes.NewReader(o.Body)),
+		ETag:     aws.String(o.ETag),
+		Metadata: aws.StringMap(o.Metadata),
+	}, nil
+}
+
+// HeadObject return the object ETag and metadata
+func (s *S3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("HeadObject"); err != nil {
+		return nil, err
+	}
+
+	o, ok := s.objects[path(input.Bucket, input.Key)]
+	if !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+
+	return &s3.HeadObjectOutput{
+		ETag:     aws.String(o.ETag),
+		Metadata: aws.StringMap(o.Metadata),
+	}, nil
+}
+
+// PutObjectWithContext store the object, honoring the If-Match and
+// If-None-Match headers set by the request options
+func (s *S3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	r.ApplyOptions(opts...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("PutObject"); err != nil {
+		return nil, err
+	}
+
+	key := path(input.Bucket, input.Key)
+	current, exists := s.objects[key]
+
+	failed := awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	if r.HTTPRequest.Header.Get("If-None-Match") == "*" && exists {
+		return nil, failed
+	}
+	if m := r.HTTPRequest.Header.Get("If-Match"); m != "" && (!exists || current.ETag != m) {
+		return nil, failed
+	}
+
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Object{
+		Body:                 body,
+		ETag:                 etag(body),
+		Metadata:             aws.StringValueMap(input.Metadata),
+		ServerSideEncryption: aws.StringValue(input.ServerSideEncryption),
+		SSEKMSKeyID:          aws.StringValue(input.SSEKMSKeyId),
+	}
+	s.objects[key] = o
+
+	return &s3.PutObjectOutput{ETag: aws.String(o.ETag)}, nil
+}
+
+// ListObjectsV2Pages call fn with the keys under the prefix, 2 by 2 to
+// exercise the pagination
+func (s *S3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	s.mutex.Lock()
+
+	if err := s.call("ListObjectsV2"); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+
+	prefix := path(input.Bucket, input.Prefix)
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, strings.TrimPrefix(k, aws.StringValue(input.Bucket)+"/"))
+		}
+	}
+	s.mutex.Unlock()
+	sort.Strings(keys)
+
+	for start := 0; start < len(keys) || start == 0; start += 2 {
+		end := start + 2
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		page := &s3.ListObjectsV2Output{}
+		for _, k := range keys[start:end] {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(k)})
+		}
+
+		if !fn(page, end == len(keys)) || end == len(keys) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// DeleteObject delete the object, deleting a missing object succeeds
+func (s *S3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("DeleteObject"); err != nil {
+		return nil, err
+	}
+
+	delete(s.objects, path(input.Bucket, input.Key))
+
+	return &s3.DeleteObjectOutput{}, nil
+}
@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/spf13/viper"
+)
+
+// region return `s3Region`, the SSM region when not set
+func region() string {
+	if viper.GetString("s3Region") == "" {
+		return viper.GetString("ssmRegion")
+	}
+
+	return viper.GetString("s3Region")
+}
+
+// newAWSSession build the session used to reach the bucket. `s3Endpoint` and
+// `s3ForcePathStyle` allow to use S3 compatible stores such as MinIO.
+func newAWSSession() (*session.Session, error) {
+	config := aws.NewConfig().WithRegion(region())
+	if viper.GetString("s3Endpoint") != "" {
+		config.WithEndpoint(viper.GetString("s3Endpoint"))
+	}
+	if viper.GetBool("s3ForcePathStyle") {
+		config.WithS3ForcePathStyle(true)
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           viper.GetString("s3AwsProfile"),
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+/*Source describe where the S3 profiles are read from: the region, endpoint, bucket and prefix*/
+func Source() string {
+	return strings.Join([]string{region(), viper.GetString("s3Endpoint"), bucket(), keyPrefix()}, " ")
+}
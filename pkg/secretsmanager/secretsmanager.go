@@ -0,0 +1,364 @@
+package secretsmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultNamePrefix = "profiler/"
+
+	/*StageCurrent is the version stage of the current value of a secret*/
+	StageCurrent = "AWSCURRENT"
+	/*StagePrevious is the version stage of the value replaced by the current one*/
+	StagePrevious = "AWSPREVIOUS"
+
+	maxUpdateAttempts = 5
+	updateRetryDelay  = 100 * time.Millisecond
+)
+
+/*ErrConflict is returned when a profile keeps being modified concurrently*/
+var ErrConflict = errors.New("the profile has been concurrently modified, please retry")
+
+// service is the Secrets Manager client used by the package, when set it
+// replaces the default client built from the configuration
+var service secretsmanageriface.SecretsManagerAPI
+
+// defaultService is built once so that an assumed role (and its MFA prompt)
+// is shared by every call of the command
+var defaultService secretsmanageriface.SecretsManagerAPI
+
+/*SetService override the Secrets Manager client used by the package (nil restore the default one)*/
+func SetService(svc secretsmanageriface.SecretsManagerAPI) {
+	service = svc
+}
+
+func newSecretsManagerService() secretsmanageriface.SecretsManagerAPI {
+	if service != nil {
+		return service
+	}
+
+	if defaultService == nil {
+		mySession, err := newAWSSession()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		defaultService = secretsmanager.New(mySession)
+	}
+
+	return defaultService
+}
+
+// namePrefix return the prefix of the profiles secrets name
+// (`secretsManagerPrefix`)
+func namePrefix() string {
+	if viper.GetString("secretsManagerPrefix") == "" {
+		return defaultNamePrefix
+	}
+
+	return viper.GetString("secretsManagerPrefix")
+}
+
+func secretName(profileName string) string {
+	return namePrefix() + profileName
+}
+
+// profileTag return the key and value of the `secretsManagerTag` tag
+// ("key=value", or just "key" for any value) the profiles are tagged with
+func profileTag() (string, string) {
+	tag := strings.SplitN(viper.GetString("secretsManagerTag"), "=", 2)
+	if len(tag) == 1 {
+		return tag[0], ""
+	}
+
+	return tag[0], tag[1]
+}
+
+func hasTag(tags []*secretsmanager.Tag, key string, value string) bool {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key && (value == "" || aws.StringValue(t.Value) == value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	return aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	output, err := newSecretsManagerService().DescribeSecret(&secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretName(profileName)),
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// A deleted secret is kept until the end of its recovery window:
+	return output.DeletedDate == nil, nil
+}
+
+/*ListProfiles return the name of the Secrets Manager profiles as []string*/
+func ListProfiles() ([]string, error) {
+	input := &secretsmanager.ListSecretsInput{
+		Filters: []*secretsmanager.Filter{{
+			Key:    aws.String(secretsmanager.FilterNameStringTypeName),
+			Values: []*string{aws.String(namePrefix())},
+		}},
+	}
+
+	tagKey, tagValue := profileTag()
+	if tagKey != "" {
+		input.Filters = append(input.Filters, &secretsmanager.Filter{
+			Key:    aws.String(secretsmanager.FilterNameStringTypeTagKey),
+			Values: []*string{aws.String(tagKey)},
+		})
+	}
+
+	var profiles []string
+	err := newSecretsManagerService().ListSecretsPages(input, func(page *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+		for _, s := range page.SecretList {
+			// The filters are case-insensitive prefix matches:
+			if !strings.HasPrefix(aws.StringValue(s.Name), namePrefix()) || s.DeletedDate != nil {
+				continue
+			}
+			if tagKey != "" && !hasTag(s.Tags, tagKey, tagValue) {
+				continue
+			}
+
+			profiles = append(profiles, strings.TrimPrefix(aws.StringValue(s.Name), namePrefix()))
+		}
+
+		return true
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+/*GetProfile retrieve the variables of the given version stage (AWSCURRENT when empty) of the profile*/
+func GetProfile(profileName string, stage string) (map[string]string, error) {
+	vars, _, err := readProfile(profileName, stage)
+
+	return vars, err
+}
+
+// readProfile return the variables and the version ID of the given version
+// stage (AWSCURRENT when empty) of the profile
+func readProfile(profileName string, stage string) (map[string]string, string, error) {
+	if stage == "" {
+		stage = StageCurrent
+	}
+
+	output, err := newSecretsManagerService().GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretName(profileName)),
+		VersionStage: aws.String(stage),
+	})
+	if isNotFound(err) {
+		if stage != StageCurrent {
+			return map[string]string{}, "", fmt.Errorf("profile %s has no %s version", profileName, stage)
+		}
+		return map[string]string{}, "", fmt.Errorf("profile %s not found", profileName)
+	}
+	if err != nil {
+		return map[string]string{}, "", err
+	}
+
+	vars := make(map[string]string)
+	err = json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &vars)
+	if err != nil {
+		return map[string]string{}, "", fmt.Errorf("the %s secret is not a JSON object of variables: %w", secretName(profileName), err)
+	}
+
+	return vars, aws.StringValue(output.VersionId), nil
+}
+
+/*ShowProfile return the list of keys of the given version stage of a profile*/
+func ShowProfile(profileName string, stage string) ([]string, error) {
+	vars, err := GetProfile(profileName, stage)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// currentVersionID return the ID of the AWSCURRENT version of the profile
+func currentVersionID(profileName string) (string, error) {
+	output, err := newSecretsManagerService().DescribeSecret(&secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretName(profileName)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for id, stages := range output.VersionIdsToStages {
+		for _, stage := range stages {
+			if aws.StringValue(stage) == StageCurrent {
+				return id, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func isResourceExists(err error) bool {
+	var aerr awserr.Error
+
+	return errors.As(err, &aerr) && aerr.Code() == secretsmanager.ErrCodeResourceExistsException
+}
+
+// updateProfile apply fn to the current variables of the profile and store
+// them as the new current version, creating the secret if needed. Secrets
+// Manager having no check-and-set, the write is retried when the current
+// version changed since it has been read.
+func updateProfile(profileName string, fn func(vars map[string]string) error) error {
+	svc := newSecretsManagerService()
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * updateRetryDelay)
+		}
+
+		exist, err := ProfileExist(profileName)
+		if err != nil {
+			return err
+		}
+
+		vars := map[string]string{"profile_name": profileName}
+		var versionID string
+		if exist {
+			vars, versionID, err = readProfile(profileName, StageCurrent)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = fn(vars)
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(vars)
+		if err != nil {
+			return err
+		}
+
+		if exist {
+			current, err := currentVersionID(profileName)
+			if err != nil {
+				return err
+			}
+			if current != versionID {
+				continue
+			}
+
+			// The previous value gets the AWSPREVIOUS stage:
+			_, err = svc.PutSecretValue(&secretsmanager.PutSecretValueInput{
+				SecretId:     aws.String(secretName(profileName)),
+				SecretString: aws.String(string(value)),
+			})
+
+			return err
+		}
+
+		input := &secretsmanager.CreateSecretInput{
+			Name:         aws.String(secretName(profileName)),
+			SecretString: aws.String(string(value)),
+		}
+		if viper.GetString("secretsManagerKmsKeyId") != "" {
+			input.SetKmsKeyId(viper.GetString("secretsManagerKmsKeyId"))
+		}
+		if tagKey, tagValue := profileTag(); tagKey != "" {
+			input.SetTags([]*secretsmanager.Tag{{Key: aws.String(tagKey), Value: aws.String(tagValue)}})
+		}
+
+		// The profile may have been created since it has been read:
+		_, err = svc.CreateSecret(input)
+		if isResourceExists(err) {
+			continue
+		}
+
+		return err
+	}
+
+	return ErrConflict
+}
+
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	return updateProfile(profileName, func(vars map[string]string) error {
+		for i := 0; i < len(KVs); i += 2 {
+			vars[KVs[i]] = KVs[i+1]
+		}
+
+		return nil
+	})
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	return updateProfile(profileName, func(vars map[string]string) error {
+		if _, ok := vars[key]; !ok {
+			return fmt.Errorf("%s not found in the %s profile", key, profileName)
+		}
+
+		delete(vars, key)
+
+		return nil
+	})
+}
+
+/*DeleteProfile schedule the deletion of the given profile secret, immediately when force is set*/
+func DeleteProfile(profileName string, force bool) error {
+	input := &secretsmanager.DeleteSecretInput{
+		SecretId: aws.String(secretName(profileName)),
+	}
+	if force {
+		input.SetForceDeleteWithoutRecovery(true)
+	} else if viper.GetInt64("secretsManagerRecoveryWindow") > 0 {
+		input.SetRecoveryWindowInDays(viper.GetInt64("secretsManagerRecoveryWindow"))
+	}
+
+	_, err := newSecretsManagerService().DeleteSecret(input)
+
+	return err
+}
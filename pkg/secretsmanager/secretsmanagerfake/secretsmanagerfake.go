@@ -0,0 +1,348 @@
+// Package secretsmanagerfake provides an in-memory AWS Secrets Manager
+// implementing the subset of secretsmanageriface.SecretsManagerAPI used by
+// Profiler, to test the Secrets Manager backend offline.
+package secretsmanagerfake
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// pageSize is the number of secrets per page of ListSecrets, small to
+// exercise the pagination
+const pageSize = 2
+
+// Secret is a stored secret
+type Secret struct {
+	KmsKeyID    string
+	Tags        map[string]string
+	DeletedDate *time.Time
+
+	// Versions are the values of the secret, by version stage
+	Versions map[string]string
+	// VersionIDs are the IDs of the versions of the secret, by version stage
+	VersionIDs map[string]string
+}
+
+// SecretsManager is an in-memory Secrets Manager. Calling an operation it
+// doesn't implement panics.
+type SecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	mutex    sync.Mutex
+	secrets  map[string]*Secret
+	failures map[string][]error
+	versions int
+
+	// Calls count the calls of each operation
+	Calls map[string]int
+}
+
+// New return an empty secrets store
+func New() *SecretsManager {
+	return &SecretsManager{
+		secrets:  make(map[string]*Secret),
+		failures: make(map[string][]error),
+		Calls:    make(map[string]int),
+	}
+}
+
+// FailNext make the next calls of the given operation (e.g. "GetSecretValue")
+// return the given errors, one per call
+func (s *SecretsManager) FailNext(operation string, errs ...error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures[operation] = append(s.failures[operation], errs...)
+}
+
+// versionID return the ID of a new version, the caller holding the lock
+func (s *SecretsManager) versionID() string {
+	s.versions++
+
+	return "version-" + strconv.Itoa(s.versions)
+}
+
+// call record the call of the operation and return the error it has to fail
+// with, the caller holding the lock
+func (s *SecretsManager) call(operation string) error {
+	s.Calls[operation]++
+
+	if errs := s.failures[operation]; len(errs) > 0 {
+		s.failures[operation] = errs[1:]
+		return errs[0]
+	}
+
+	return nil
+}
+
+// Get return a copy of the stored secret, nil when it doesn't exist
+func (s *SecretsManager) Get(name string) *Secret {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	secret, ok := s.secrets[name]
+	if !ok {
+		return nil
+	}
+	c := *secret
+
+	return &c
+}
+
+func notFound() error {
+	return awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "Secrets Manager can't find the specified secret.", nil)
+}
+
+func deleted() error {
+	return awserr.New(secretsmanager.ErrCodeInvalidRequestException, "You can't perform this operation on the secret because it was marked for deletion.", nil)
+}
+
+func tags(secret *Secret) []*secretsmanager.Tag {
+	var t []*secretsmanager.Tag
+	for k, v := range secret.Tags {
+		t = append(t, &secretsmanager.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	return t
+}
+
+// CreateSecret store a new secret, its value being the AWSCURRENT version
+func (s *SecretsManager) CreateSecret(input *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("CreateSecret"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.Name)
+	if current, ok := s.secrets[name]; ok {
+		if current.DeletedDate != nil {
+			return nil, deleted()
+		}
+		return nil, awserr.New(secretsmanager.ErrCodeResourceExistsException, "The secret already exists.", nil)
+	}
+
+	secret := &Secret{
+		KmsKeyID:   aws.StringValue(input.KmsKeyId),
+		Tags:       make(map[string]string),
+		Versions:   map[string]string{"AWSCURRENT": aws.StringValue(input.SecretString)},
+		VersionIDs: map[string]string{"AWSCURRENT": s.versionID()},
+	}
+	for _, t := range input.Tags {
+		secret.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	s.secrets[name] = secret
+
+	return &secretsmanager.CreateSecretOutput{Name: input.Name}, nil
+}
+
+// DescribeSecret return the secret metadata
+func (s *SecretsManager) DescribeSecret(input *secretsmanager.DescribeSecretInput) (*secretsmanager.DescribeSecretOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("DescribeSecret"); err != nil {
+		return nil, err
+	}
+
+	secret, ok := s.secrets[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, notFound()
+	}
+
+	stages := make(map[string][]*string)
+	for stage, id := range secret.VersionIDs {
+		stages[id] = append(stages[id], aws.String(stage))
+	}
+
+	return &secretsmanager.DescribeSecretOutput{
+		Name:               input.SecretId,
+		KmsKeyId:           aws.String(secret.KmsKeyID),
+		DeletedDate:        secret.DeletedDate,
+		Tags:               tags(secret),
+		VersionIdsToStages: stages,
+	}, nil
+}
+
+// GetSecretValue return the value of the requested version stage
+// (AWSCURRENT by default)
+func (s *SecretsManager) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("GetSecretValue"); err != nil {
+		return nil, err
+	}
+
+	secret, ok := s.secrets[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, notFound()
+	}
+	if secret.DeletedDate != nil {
+		return nil, deleted()
+	}
+
+	stage := aws.StringValue(input.VersionStage)
+	if stage == "" {
+		stage = "AWSCURRENT"
+	}
+
+	value, ok := secret.Versions[stage]
+	if !ok {
+		return nil, notFound()
+	}
+
+	return &secretsmanager.GetSecretValueOutput{
+		Name:          input.SecretId,
+		SecretString:  aws.String(value),
+		VersionId:     aws.String(secret.VersionIDs[stage]),
+		VersionStages: []*string{aws.String(stage)},
+	}, nil
+}
+
+// PutSecretValue store a new AWSCURRENT version, the current one becoming
+// AWSPREVIOUS
+func (s *SecretsManager) PutSecretValue(input *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("PutSecretValue"); err != nil {
+		return nil, err
+	}
+
+	secret, ok := s.secrets[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, notFound()
+	}
+	if secret.DeletedDate != nil {
+		return nil, deleted()
+	}
+
+	secret.Versions["AWSPREVIOUS"] = secret.Versions["AWSCURRENT"]
+	secret.Versions["AWSCURRENT"] = aws.StringValue(input.SecretString)
+	secret.VersionIDs["AWSPREVIOUS"] = secret.VersionIDs["AWSCURRENT"]
+	secret.VersionIDs["AWSCURRENT"] = s.versionID()
+
+	return &secretsmanager.PutSecretValueOutput{
+		Name:      input.SecretId,
+		VersionId: aws.String(secret.VersionIDs["AWSCURRENT"]),
+	}, nil
+}
+
+// matches tell if the secret matches every filter, as case-insensitive
+// prefixes like the real API
+func matches(name string, secret *Secret, filters []*secretsmanager.Filter) bool {
+	for _, f := range filters {
+		var candidates []string
+		switch aws.StringValue(f.Key) {
+		case secretsmanager.FilterNameStringTypeName:
+			candidates = []string{name}
+		case secretsmanager.FilterNameStringTypeTagKey:
+			for k := range secret.Tags {
+				candidates = append(candidates, k)
+			}
+		case secretsmanager.FilterNameStringTypeTagValue:
+			for _, v := range secret.Tags {
+				candidates = append(candidates, v)
+			}
+		}
+
+		found := false
+		for _, c := range candidates {
+			for _, v := range f.Values {
+				if strings.HasPrefix(strings.ToLower(c), strings.ToLower(aws.StringValue(v))) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListSecretsPages call fn with the secrets matching the filters, 2 by 2
+func (s *SecretsManager) ListSecretsPages(input *secretsmanager.ListSecretsInput, fn func(*secretsmanager.ListSecretsOutput, bool) bool) error {
+	s.mutex.Lock()
+
+	if err := s.call("ListSecrets"); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+
+	var names []string
+	for name, secret := range s.secrets {
+		if matches(name, secret, input.Filters) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var pages []*secretsmanager.ListSecretsOutput
+	for start := 0; start < len(names) || start == 0; start += pageSize {
+		end := start + pageSize
+		if end > len(names) {
+			end = len(names)
+		}
+
+		page := &secretsmanager.ListSecretsOutput{}
+		for _, name := range names[start:end] {
+			page.SecretList = append(page.SecretList, &secretsmanager.SecretListEntry{
+				Name:        aws.String(name),
+				DeletedDate: s.secrets[name].DeletedDate,
+				Tags:        tags(s.secrets[name]),
+			})
+		}
+		if end < len(names) {
+			page.NextToken = aws.String(strconv.Itoa(end))
+		}
+		pages = append(pages, page)
+	}
+	s.mutex.Unlock()
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// DeleteSecret mark the secret as deleted, or remove it immediately when
+// ForceDeleteWithoutRecovery is set
+func (s *SecretsManager) DeleteSecret(input *secretsmanager.DeleteSecretInput) (*secretsmanager.DeleteSecretOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("DeleteSecret"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.SecretId)
+	secret, ok := s.secrets[name]
+	if !ok {
+		return nil, notFound()
+	}
+
+	if aws.BoolValue(input.ForceDeleteWithoutRecovery) {
+		delete(s.secrets, name)
+	} else {
+		now := time.Now()
+		secret.DeletedDate = &now
+	}
+
+	return &secretsmanager.DeleteSecretOutput{Name: input.SecretId}, nil
+}
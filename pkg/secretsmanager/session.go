@@ -0,0 +1,46 @@
+package secretsmanager
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/spf13/viper"
+)
+
+// region return `secretsManagerRegion`, the SSM region when not set
+func region() string {
+	if viper.GetString("secretsManagerRegion") == "" {
+		return viper.GetString("ssmRegion")
+	}
+
+	return viper.GetString("secretsManagerRegion")
+}
+
+// newAWSSession build the session used to reach Secrets Manager.
+// `secretsManagerEndpoint` allows to use a local stand-in such as LocalStack.
+func newAWSSession() (*session.Session, error) {
+	config := aws.NewConfig().WithRegion(region())
+	if viper.GetString("secretsManagerEndpoint") != "" {
+		config.WithEndpoint(viper.GetString("secretsManagerEndpoint"))
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:                  *config,
+		Profile:                 viper.GetString("secretsManagerAwsProfile"),
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	})
+}
+
+/*Source describe where the Secrets Manager profiles are read from: the region, endpoint, credentials, prefix and tag*/
+func Source() string {
+	return strings.Join([]string{
+		region(),
+		viper.GetString("secretsManagerEndpoint"),
+		viper.GetString("secretsManagerAwsProfile"),
+		namePrefix(),
+		viper.GetString("secretsManagerTag"),
+	}, " ")
+}
@@ -0,0 +1,177 @@
+package ssm
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/spf13/viper"
+)
+
+// deleteBatchSize is the maximum number of parameters DeleteParameters accepts
+const deleteBatchSize = 10
+
+// retryBaseDelay is the first backoff delay when SSM throttles the requests
+var retryBaseDelay = 200 * time.Millisecond
+
+/*BulkError report the variables a bulk operation failed to write or delete*/
+type BulkError struct {
+	Total    int
+	Failures map[string]error
+}
+
+func (e *BulkError) Error() string {
+	var keys []string
+	for k := range e.Failures {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := []string{
+		fmt.Sprintf("%d of %d variables failed:", len(e.Failures), e.Total),
+	}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("- %s: %s", k, e.Failures[k]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isThrottling(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "ThrottlingException", ssm.ErrCodeTooManyUpdates:
+			return true
+		}
+	}
+
+	return false
+}
+
+// withBackoff call fn until it succeeds, fails with a non throttling error or
+// `ssmMaxRetries` retries are exhausted, doubling the delay between attempts
+func withBackoff(fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isThrottling(err) || attempt >= viper.GetInt("ssmMaxRetries") {
+			return err
+		}
+
+		// Add some jitter so that the workers don't retry all at once:
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+	}
+}
+
+/*AddParameters write every given variable to the profile using a bounded pool
+of `ssmConcurrency` workers. Existing variables are overwritten.*/
+func AddParameters(profileName string, vars map[string]string, descriptions map[string]string, secure bool) error {
+	svc := newSSMService()
+
+	workers := viper.GetInt("ssmConcurrency")
+	if workers < 1 {
+		workers = 1
+	}
+
+	keys := make(chan string)
+	failures := make(map[string]error)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				input := newPutParameterInput(profileName, key, vars[key], descriptions[key], secure)
+				err := withBackoff(func() error {
+					_, err := svc.PutParameter(input)
+					return err
+				})
+
+				// Tags can't be set when overwriting a parameter:
+				if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterAlreadyExists {
+					input.SetTags(nil)
+					input.SetOverwrite(true)
+					err = withBackoff(func() error {
+						_, err := svc.PutParameter(input)
+						return err
+					})
+				}
+
+				if err != nil {
+					mutex.Lock()
+					failures[key] = err
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for key := range vars {
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return &BulkError{Total: len(vars), Failures: failures}
+	}
+
+	return nil
+}
+
+/*RemoveProfile delete every parameter of the profile by batches of 10*/
+func RemoveProfile(profileName string) error {
+	svc := newSSMService()
+
+	params, err := getParameters(profilePath(profileName))
+	if err != nil {
+		return err
+	}
+
+	failures := make(map[string]error)
+	for start := 0; start < len(params); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(params) {
+			end = len(params)
+		}
+
+		var names []*string
+		for _, p := range params[start:end] {
+			names = append(names, p.Name)
+		}
+
+		var output *ssm.DeleteParametersOutput
+		err := withBackoff(func() error {
+			var err error
+			output, err = svc.DeleteParameters(
+				&ssm.DeleteParametersInput{Names: names},
+			)
+			return err
+		})
+		if err != nil {
+			for _, name := range names {
+				failures[keyName(profileName, *name)] = err
+			}
+			continue
+		}
+
+		for _, name := range output.InvalidParameters {
+			failures[keyName(profileName, aws.StringValue(name))] = fmt.Errorf("invalid parameter %s", aws.StringValue(name))
+		}
+	}
+
+	if len(failures) > 0 {
+		return &BulkError{Total: len(params), Failures: failures}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package ssm
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/spf13/viper"
+)
+
+/*ParameterVersion is a version of a profile variable*/
+type ParameterVersion struct {
+	Key              string
+	Version          int64
+	Value            string
+	Type             string
+	KeyID            string
+	Description      string
+	Tier             string
+	LastModifiedDate time.Time
+	LastModifiedUser string
+}
+
+/*RollbackTarget is the point a profile is rolled back to: either a date or a
+version number of its variables*/
+type RollbackTarget struct {
+	Time    time.Time
+	Version int64
+}
+
+func (t RollbackTarget) includes(v ParameterVersion) bool {
+	if t.Version > 0 {
+		return v.Version <= t.Version
+	}
+
+	return !v.LastModifiedDate.After(t.Time)
+}
+
+/*RollbackChange is a variable update needed to roll a profile back*/
+type RollbackChange struct {
+	Key     string
+	Current ParameterVersion
+	// Target is nil when the variable didn't exist yet and has to be deleted
+	Target *ParameterVersion
+}
+
+// String return the line of the change in the rollback plan, the values of
+// the encrypted variables not being displayed
+func (c RollbackChange) String() string {
+	if c.Target == nil {
+		return fmt.Sprintf("- %s (v%d, did not exist yet)", c.Key, c.Current.Version)
+	}
+
+	if c.Target.Type == ssm.ParameterTypeSecureString || c.Current.Type == ssm.ParameterTypeSecureString {
+		return fmt.Sprintf("~ %s v%d -> v%d (encrypted)", c.Key, c.Current.Version, c.Target.Version)
+	}
+
+	return fmt.Sprintf(
+		"~ %s v%d -> v%d (%q -> %q)",
+		c.Key,
+		c.Current.Version,
+		c.Target.Version,
+		c.Current.Value,
+		c.Target.Value,
+	)
+}
+
+func parameterHistory(profileName string, key string) ([]ParameterVersion, error) {
+	svc := newSSMService()
+
+	var input = &ssm.GetParameterHistoryInput{}
+	input.SetName(parameterPath(profileName, key))
+	input.SetWithDecryption(true)
+
+	var versions []ParameterVersion
+	for {
+		output, err := svc.GetParameterHistory(input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range output.Parameters {
+			versions = append(versions, ParameterVersion{
+				Key:              key,
+				Version:          aws.Int64Value(p.Version),
+				Value:            aws.StringValue(p.Value),
+				Type:             aws.StringValue(p.Type),
+				KeyID:            aws.StringValue(p.KeyId),
+				Description:      aws.StringValue(p.Description),
+				Tier:             aws.StringValue(p.Tier),
+				LastModifiedDate: aws.TimeValue(p.LastModifiedDate),
+				LastModifiedUser: aws.StringValue(p.LastModifiedUser),
+			})
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			break
+		}
+		input.SetNextToken(*output.NextToken)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+/*History return the versions of the given profile variable, or of every
+variable of the profile when key is empty, oldest first*/
+func History(profileName string, key string) (map[string][]ParameterVersion, error) {
+	keys := []string{key}
+	if key == "" {
+		var err error
+		keys, err = ShowProfile(profileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	history := make(map[string][]ParameterVersion)
+	for _, k := range keys {
+		versions, err := parameterHistory(profileName, k)
+		if err != nil {
+			return nil, err
+		}
+		history[k] = versions
+	}
+
+	return history, nil
+}
+
+/*PlanRollback return the changes needed to restore every variable of the
+profile to its value at the given target. Variables deleted since then can't
+be restored as SSM drops the history of deleted parameters.*/
+func PlanRollback(profileName string, target RollbackTarget) ([]RollbackChange, error) {
+	history, err := History(profileName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RollbackChange
+	for key, versions := range history {
+		if len(versions) == 0 {
+			continue
+		}
+
+		current := versions[len(versions)-1]
+
+		var restored *ParameterVersion
+		for i := range versions {
+			if target.includes(versions[i]) {
+				restored = &versions[i]
+			}
+		}
+
+		if restored != nil && restored.Version == current.Version {
+			continue
+		}
+
+		changes = append(changes, RollbackChange{
+			Key:     key,
+			Current: current,
+			Target:  restored,
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes, nil
+}
+
+// rollbackTier return the tier of the restored version, an Advanced parameter
+// being kept Advanced as it can't be downgraded, and `ssmParameterTier` when
+// the history doesn't tell
+func rollbackTier(change RollbackChange) string {
+	if change.Current.Tier == ssm.ParameterTierAdvanced {
+		return change.Current.Tier
+	}
+	if change.Target.Tier != "" {
+		return change.Target.Tier
+	}
+
+	return viper.GetString("ssmParameterTier")
+}
+
+/*ApplyRollback write the planned changes to the profile*/
+func ApplyRollback(profileName string, changes []RollbackChange) error {
+	svc := newSSMService()
+
+	failures := make(map[string]error)
+	for _, change := range changes {
+		var err error
+		if change.Target == nil {
+			err = withBackoff(func() error {
+				return RemoveParameter(profileName, change.Key)
+			})
+		} else {
+			var input = &ssm.PutParameterInput{}
+			input.SetName(parameterPath(profileName, change.Key))
+			input.SetType(change.Target.Type)
+			if change.Target.KeyID != "" && change.Target.Type == ssm.ParameterTypeSecureString {
+				input.SetKeyId(change.Target.KeyID)
+			}
+			input.SetValue(change.Target.Value)
+			input.SetOverwrite(true)
+			input.SetTier(rollbackTier(change))
+			if change.Target.Description != "" {
+				input.SetDescription(change.Target.Description)
+			}
+
+			err = withBackoff(func() error {
+				_, err := svc.PutParameter(input)
+				return err
+			})
+		}
+
+		if err != nil {
+			failures[change.Key] = err
+		}
+	}
+
+	if len(failures) > 0 {
+		return &BulkError{Total: len(changes), Failures: failures}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package ssm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const defaultPathPrefix = "/profiler/"
+
+// mount is a SSM path prefix holding profiles. Profiles of named mounts are
+// identified as `<mount>/<profile>`.
+type mount struct {
+	name   string
+	prefix string
+}
+
+func normalizePrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	return prefix
+}
+
+// defaultPrefix return the `ssmPathPrefix` holding the profiles without mount
+func defaultPrefix() string {
+	if viper.GetString("ssmPathPrefix") == "" {
+		return defaultPathPrefix
+	}
+
+	return normalizePrefix(viper.GetString("ssmPathPrefix"))
+}
+
+// mounts return the default path prefix followed by the `ssmMounts` ones
+func mounts() []mount {
+	mounts := []mount{{prefix: defaultPrefix()}}
+
+	named := viper.GetStringMapString("ssmMounts")
+	var names []string
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mounts = append(mounts, mount{name: name, prefix: normalizePrefix(named[name])})
+	}
+
+	return mounts
+}
+
+// profilePath return the SSM path of the given profile (without trailing
+// slash), resolving its mount from the `<mount>/<profile>` form
+func profilePath(profileName string) string {
+	if i := strings.Index(profileName, "/"); i > 0 {
+		named := viper.GetStringMapString("ssmMounts")
+		if prefix, ok := named[strings.ToLower(profileName[:i])]; ok {
+			return normalizePrefix(prefix) + profileName[i+1:]
+		}
+	}
+
+	return defaultPrefix() + profileName
+}
+
+// parameterPath return the SSM path of a profile variable
+func parameterPath(profileName, key string) string {
+	return profilePath(profileName) + "/" + key
+}
+
+// splitParameterName return the profile and the variable names of a parameter
+// stored under the given prefix (`<prefix><profile>[/<sub path>]/<KEY>`).
+// Parameters that are not part of a profile are reported with ok set to false.
+func splitParameterName(prefix, name string) (profileName string, key string, ok bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(name, prefix), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" || strings.HasSuffix(parts[1], "/") {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// keyName return the variable name of a parameter of the given profile: its
+// path relative to the profile, so that the parameters of sub paths (e.g.
+// `nested/BAR`) can be told apart and addressed like the others
+func keyName(profileName string, name string) string {
+	return strings.TrimPrefix(name, profilePath(profileName)+"/")
+}
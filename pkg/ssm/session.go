@@ -0,0 +1,67 @@
+package ssm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/spf13/viper"
+)
+
+/*NewAWSSession build the session used to reach SSM. The credentials are resolved from `ssmAwsProfile` and `ssmRoleArn` when configured, so that SSM access doesn't depend on the AWS credentials exported by the active profile.*/
+func NewAWSSession() (*session.Session, error) {
+	config := aws.NewConfig().WithRegion(viper.GetString("ssmRegion"))
+	if viper.GetString("ssmEndpoint") != "" {
+		config.WithEndpoint(viper.GetString("ssmEndpoint"))
+	}
+
+	options := session.Options{
+		Config: *config,
+		// An explicit profile takes precedence over the environment credentials:
+		Profile:                 viper.GetString("ssmAwsProfile"),
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	}
+
+	mySession, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if viper.GetString("ssmRoleArn") == "" {
+		return mySession, nil
+	}
+
+	creds := stscreds.NewCredentials(
+		mySession,
+		viper.GetString("ssmRoleArn"),
+		func(p *stscreds.AssumeRoleProvider) {
+			if viper.GetString("ssmRoleSessionName") != "" {
+				p.RoleSessionName = viper.GetString("ssmRoleSessionName")
+			}
+			if viper.GetString("ssmRoleExternalId") != "" {
+				p.ExternalID = aws.String(viper.GetString("ssmRoleExternalId"))
+			}
+			if viper.GetString("ssmRoleMfaSerial") != "" {
+				p.SerialNumber = aws.String(viper.GetString("ssmRoleMfaSerial"))
+				p.TokenProvider = stscreds.StdinTokenProvider
+			}
+		},
+	)
+
+	return mySession.Copy(aws.NewConfig().WithCredentials(creds)), nil
+}
+
+/*Source describe where the SSM profiles are read from: the region, endpoint, credentials and paths*/
+func Source() string {
+	return strings.Join([]string{
+		viper.GetString("ssmRegion"),
+		viper.GetString("ssmEndpoint"),
+		viper.GetString("ssmAwsProfile"),
+		viper.GetString("ssmRoleArn"),
+		defaultPrefix(),
+		fmt.Sprint(viper.GetStringMapString("ssmMounts")),
+	}, " ")
+}
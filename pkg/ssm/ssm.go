@@ -6,22 +6,61 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/spf13/viper"
+
+	"github.com/julienlevasseur/profiler/pkg/meta"
 )
 
-func newSSMService() *ssm.SSM {
-	mySession := session.Must(session.NewSession())
+// secretKeyPatterns are the variable name parts identifying a secret
+var secretKeyPatterns = []string{
+	"SECRET",
+	"PASSWORD",
+	"PASSWD",
+	"TOKEN",
+	"PRIVATE",
+	"API_KEY",
+	"APIKEY",
+	"CREDENTIAL",
+}
+
+const (
+	metaDescriptionTag = "profiler:description"
+	metaOwnerTag       = "profiler:owner"
+	metaTagPrefix      = "profiler:tag:"
+)
 
-	// Create a SSM client from just a session.
-	svc := ssm.New(
-		mySession, aws.NewConfig().WithRegion(
-			viper.GetString("ssmRegion"),
-		),
-	)
+// service is the SSM client used by the package, when set it replaces the
+// default client built from the configuration
+var service ssmiface.SSMAPI
+
+// defaultService is built once so that an assumed role (and its MFA prompt)
+// is shared by every call of the command
+var defaultService ssmiface.SSMAPI
+
+/*SetService override the SSM client used by the package (nil restore the default one)*/
+func SetService(svc ssmiface.SSMAPI) {
+	service = svc
+}
+
+func newSSMService() ssmiface.SSMAPI {
+	if service != nil {
+		return service
+	}
+
+	if defaultService == nil {
+		mySession, err := NewAWSSession()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		// Create a SSM client from just a session.
+		defaultService = ssm.New(mySession)
+	}
 
-	return svc
+	return defaultService
 }
 
 func getParameters(path string) ([]*ssm.Parameter, error) {
@@ -30,14 +69,29 @@ func getParameters(path string) ([]*ssm.Parameter, error) {
 	var input = &ssm.GetParametersByPathInput{}
 	input.SetPath(path)
 	input.SetRecursive(true)
+	// SecureString parameters are transparently decrypted:
+	input.SetWithDecryption(true)
+	if viper.GetInt64("ssmPageSize") > 0 {
+		input.SetMaxResults(viper.GetInt64("ssmPageSize"))
+	}
 
-	getParametersByPathOutput, err := svc.GetParametersByPath(input)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	// The results are paginated, follow NextToken until the last page:
+	var params []*ssm.Parameter
+	for {
+		getParametersByPathOutput, err := svc.GetParametersByPath(input)
+		if err != nil {
+			return nil, err
+		}
+
+		params = append(params, getParametersByPathOutput.Parameters...)
+
+		if aws.StringValue(getParametersByPathOutput.NextToken) == "" {
+			break
+		}
+		input.SetNextToken(*getParametersByPathOutput.NextToken)
 	}
 
-	return getParametersByPathOutput.Parameters, nil
+	return params, nil
 }
 
 /*ProfileExist return a boolean representation of the given profile existence*/
@@ -68,22 +122,31 @@ func profileAlreadyListed(profiles []string, searchedProfile string) bool {
 
 /*ListProfiles return the name of the SSM profiles as []string*/
 func ListProfiles() ([]string, error) {
-	params, err := getParameters("/profiler/")
-	if err != nil {
-		return []string{}, err
-	}
-
 	var ssmProfiles []string
-	for _, p := range params {
-		profileName := strings.Split(*p.Name, "/")[2]
-		/** With SSM folders management, a profile would be listed as many time as vars
-		it contains. Since ListProfile is meant to list once every found profiles, we
-		check here if the profile has already been listed or not:*/
-		if len(ssmProfiles) == 0 || !profileAlreadyListed(ssmProfiles, profileName) {
-			ssmProfiles = append(
-				ssmProfiles,
-				profileName,
-			)
+
+	for _, m := range mounts() {
+		params, err := getParameters(m.prefix)
+		if err != nil {
+			return []string{}, err
+		}
+
+		for _, p := range params {
+			profileName, _, ok := splitParameterName(m.prefix, *p.Name)
+			if !ok {
+				continue
+			}
+			if m.name != "" {
+				profileName = m.name + "/" + profileName
+			}
+			/** With SSM folders management, a profile would be listed as many time as vars
+			it contains. Since ListProfile is meant to list once every found profiles, we
+			check here if the profile has already been listed or not:*/
+			if len(ssmProfiles) == 0 || !profileAlreadyListed(ssmProfiles, profileName) {
+				ssmProfiles = append(
+					ssmProfiles,
+					profileName,
+				)
+			}
 		}
 	}
 
@@ -92,15 +155,14 @@ func ListProfiles() ([]string, error) {
 
 /*ShowProfile list the Env vars stored in a profile*/
 func ShowProfile(profileName string) ([]string, error) {
-	params, err := getParameters("/profiler/" + profileName)
+	params, err := getParameters(profilePath(profileName))
 	if err != nil {
 		return []string{}, err
 	}
 
 	var vars []string
 	for _, p := range params {
-		varName := strings.Split(*p.Name, "/")[3]
-		vars = append(vars, varName)
+		vars = append(vars, keyName(profileName, *p.Name))
 	}
 
 	return vars, nil
@@ -108,7 +170,7 @@ func ShowProfile(profileName string) ([]string, error) {
 
 /*GetProfile retrive the given profile from AWS SSM*/
 func GetProfile(profileName string) (map[string]string, error) {
-	params, err := getParameters("/profiler/" + profileName)
+	params, err := getParameters(profilePath(profileName))
 	if err != nil {
 		return map[string]string{}, err
 	}
@@ -116,16 +178,42 @@ func GetProfile(profileName string) (map[string]string, error) {
 	vars := make(map[string]string)
 
 	for _, p := range params {
-		vars[strings.Split(*p.Name, "/")[3]] = *p.Value
+		vars[keyName(profileName, *p.Name)] = *p.Value
 	}
 
 	return vars, nil
 }
 
-/*AddParameter is used to create either Profile or Env var in SSM*/
-func AddParameter(paramName string, paramValue string) error {
-	svc := newSSMService()
+/*IsSecretKey return true if the given variable name looks like a secret*/
+func IsSecretKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, pattern := range secretKeyPatterns {
+		if strings.Contains(key, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*SecureKeys return the variables of the profile stored as SecureString*/
+func SecureKeys(profileName string) ([]string, error) {
+	params, err := getParameters(profilePath(profileName))
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for _, p := range params {
+		if aws.StringValue(p.Type) == ssm.ParameterTypeSecureString {
+			keys = append(keys, keyName(profileName, *p.Name))
+		}
+	}
+
+	return keys, nil
+}
 
+func newPutParameterInput(profileName string, key string, paramValue string, description string, secure bool) *ssm.PutParameterInput {
 	var tags []*ssm.Tag
 	tag := &ssm.Tag{
 		Key:   aws.String("profiler"),
@@ -133,18 +221,32 @@ func AddParameter(paramName string, paramValue string) error {
 	}
 	tags = append(tags, tag)
 
-	/* If only paramName is provided, Profiler assumes that it needs to create
-	a profile and not an Env var*/
-	if paramValue == "" {
-		paramName = paramName + "/"
-	}
-
 	var input = &ssm.PutParameterInput{}
-	input.SetName("/profiler/" + paramName)
-	input.SetType("String")
+	input.SetName(parameterPath(profileName, key))
+	input.SetType(ssm.ParameterTypeString)
+	// Secret looking variables (or any variable when secure is set) are
+	// encrypted, with the `ssmKmsKeyId` KMS key if configured:
+	if secure || IsSecretKey(key) {
+		input.SetType(ssm.ParameterTypeSecureString)
+		if viper.GetString("ssmKmsKeyId") != "" {
+			input.SetKeyId(viper.GetString("ssmKmsKeyId"))
+		}
+	}
 	input.SetTags(tags)
 	input.SetTier(viper.GetString("ssmParameterTier"))
 	input.SetValue(paramValue)
+	if description != "" {
+		input.SetDescription(description)
+	}
+
+	return input
+}
+
+/*AddParameter is used to create either Profile or Env var in SSM*/
+func AddParameter(profileName string, key string, paramValue string, description string, secure bool) error {
+	svc := newSSMService()
+
+	input := newPutParameterInput(profileName, key, paramValue, description, secure)
 
 	_, err := svc.PutParameter(input)
 	if err != nil {
@@ -155,11 +257,11 @@ func AddParameter(paramName string, paramValue string) error {
 }
 
 /*RemoveParameter is used to delete a Profile or Env var from SSM*/
-func RemoveParameter(paramName string) error {
+func RemoveParameter(profileName string, key string) error {
 	svc := newSSMService()
 
 	var input = &ssm.DeleteParameterInput{}
-	input.SetName(paramName)
+	input.SetName(parameterPath(profileName, key))
 
 	_, err := svc.DeleteParameter(input)
 	if err != nil {
@@ -168,3 +270,117 @@ func RemoveParameter(paramName string) error {
 
 	return nil
 }
+
+func describeParameters(path string) ([]*ssm.ParameterMetadata, error) {
+	svc := newSSMService()
+
+	var input = &ssm.DescribeParametersInput{}
+	input.SetParameterFilters([]*ssm.ParameterStringFilter{
+		{
+			Key:    aws.String("Path"),
+			Option: aws.String("Recursive"),
+			Values: []*string{aws.String(path)},
+		},
+	})
+
+	var params []*ssm.ParameterMetadata
+	for {
+		describeParametersOutput, err := svc.DescribeParameters(input)
+		if err != nil {
+			return nil, err
+		}
+
+		params = append(params, describeParametersOutput.Parameters...)
+
+		if aws.StringValue(describeParametersOutput.NextToken) == "" {
+			break
+		}
+		input.SetNextToken(*describeParametersOutput.NextToken)
+	}
+
+	return params, nil
+}
+
+/*GetMeta retrieve the profile metadata from the profile tags and parameters description*/
+func GetMeta(profileName string) (meta.Meta, error) {
+	var m meta.Meta
+	svc := newSSMService()
+
+	var input = &ssm.ListTagsForResourceInput{}
+	input.SetResourceType(ssm.ResourceTypeForTaggingParameter)
+	input.SetResourceId(parameterPath(profileName, "profile_name"))
+
+	listTagsOutput, err := svc.ListTagsForResource(input)
+	if err != nil {
+		return m, err
+	}
+
+	for _, tag := range listTagsOutput.TagList {
+		key, value := aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+		switch {
+		case key == metaDescriptionTag:
+			m.Description = value
+		case key == metaOwnerTag:
+			m.Owner = value
+		case strings.HasPrefix(key, metaTagPrefix):
+			m.AddTags([]string{strings.TrimPrefix(key, metaTagPrefix)})
+		}
+	}
+
+	params, err := describeParameters(profilePath(profileName))
+	if err != nil {
+		return m, err
+	}
+
+	for _, p := range params {
+		if aws.StringValue(p.Description) != "" {
+			m.SetKeyDescription(
+				keyName(profileName, *p.Name),
+				aws.StringValue(p.Description),
+			)
+		}
+	}
+
+	return m, nil
+}
+
+/*SetMeta store the profile level metadata as tags of the profile*/
+func SetMeta(profileName string, m meta.Meta) error {
+	svc := newSSMService()
+
+	var tags []*ssm.Tag
+	if m.Description != "" {
+		tags = append(tags, &ssm.Tag{
+			Key:   aws.String(metaDescriptionTag),
+			Value: aws.String(m.Description),
+		})
+	}
+	if m.Owner != "" {
+		tags = append(tags, &ssm.Tag{
+			Key:   aws.String(metaOwnerTag),
+			Value: aws.String(m.Owner),
+		})
+	}
+	for _, t := range m.Tags {
+		tags = append(tags, &ssm.Tag{
+			Key:   aws.String(metaTagPrefix + t),
+			Value: aws.String("true"),
+		})
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	var input = &ssm.AddTagsToResourceInput{}
+	input.SetResourceType(ssm.ResourceTypeForTaggingParameter)
+	input.SetResourceId(parameterPath(profileName, "profile_name"))
+	input.SetTags(tags)
+
+	_, err := svc.AddTagsToResource(input)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
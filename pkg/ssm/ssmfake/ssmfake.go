@@ -0,0 +1,343 @@
+// Package ssmfake provides an in-memory AWS SSM Parameter Store implementing
+// the subset of ssmiface.SSMAPI used by Profiler, to test the SSM backend
+// offline.
+package ssmfake
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// defaultPageSize is the maximum number of results per page of the real API
+const defaultPageSize = 10
+
+// SSM is an in-memory SSM Parameter Store. Calling an operation it doesn't
+// implement panics.
+type SSM struct {
+	ssmiface.SSMAPI
+
+	mutex    sync.Mutex
+	versions map[string][]*ssm.ParameterHistory
+	tags     map[string][]*ssm.Tag
+	failures map[string][]error
+
+	// Calls count the calls of each operation
+	Calls map[string]int
+}
+
+// New return an empty parameter store
+func New() *SSM {
+	return &SSM{
+		versions: make(map[string][]*ssm.ParameterHistory),
+		tags:     make(map[string][]*ssm.Tag),
+		failures: make(map[string][]error),
+		Calls:    make(map[string]int),
+	}
+}
+
+// FailNext make the next calls of the given operation (e.g. "PutParameter")
+// return the given errors, one per call
+func (s *SSM) FailNext(operation string, errs ...error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures[operation] = append(s.failures[operation], errs...)
+}
+
+// call record the call of the operation and return the error it has to fail
+// with, the caller holding the lock
+func (s *SSM) call(operation string) error {
+	s.Calls[operation]++
+
+	if errs := s.failures[operation]; len(errs) > 0 {
+		s.failures[operation] = errs[1:]
+		return errs[0]
+	}
+
+	return nil
+}
+
+func (s *SSM) latest(name string) *ssm.ParameterHistory {
+	versions := s.versions[name]
+	if len(versions) == 0 {
+		return nil
+	}
+
+	return versions[len(versions)-1]
+}
+
+// names return the sorted names of the existing parameters under path
+func (s *SSM) names(path string) []string {
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	var names []string
+	for name := range s.versions {
+		if strings.HasPrefix(name, path) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// page return the bounds of the requested page and the token of the next one
+func page(total int, token *string, maxResults *int64) (int, int, *string) {
+	start := 0
+	if token != nil {
+		start, _ = strconv.Atoi(*token)
+	}
+
+	size := defaultPageSize
+	if maxResults != nil && *maxResults > 0 && *maxResults < defaultPageSize {
+		size = int(*maxResults)
+	}
+
+	end := start + size
+	if end >= total {
+		return start, total, nil
+	}
+
+	return start, end, aws.String(strconv.Itoa(end))
+}
+
+func toParameter(h *ssm.ParameterHistory) *ssm.Parameter {
+	return &ssm.Parameter{
+		Name:             h.Name,
+		Type:             h.Type,
+		Value:            h.Value,
+		Version:          h.Version,
+		LastModifiedDate: h.LastModifiedDate,
+	}
+}
+
+// GetParametersByPath return the parameters under the given path, 10 by 10
+func (s *SSM) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("GetParametersByPath"); err != nil {
+		return nil, err
+	}
+
+	names := s.names(aws.StringValue(input.Path))
+	start, end, next := page(len(names), input.NextToken, input.MaxResults)
+
+	output := &ssm.GetParametersByPathOutput{NextToken: next}
+	for _, name := range names[start:end] {
+		output.Parameters = append(output.Parameters, toParameter(s.latest(name)))
+	}
+
+	return output, nil
+}
+
+// PutParameter create or overwrite a parameter
+func (s *SSM) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("PutParameter"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.Name)
+	current := s.latest(name)
+
+	if current != nil && !aws.BoolValue(input.Overwrite) {
+		return nil, awserr.New(ssm.ErrCodeParameterAlreadyExists, "The parameter already exists.", nil)
+	}
+	if aws.BoolValue(input.Overwrite) && len(input.Tags) > 0 {
+		return nil, awserr.New("ValidationException", "Invalid request: tags and overwrite can't be used together.", nil)
+	}
+
+	version := int64(1)
+	tier := ssm.ParameterTierStandard
+	if current != nil {
+		version = *current.Version + 1
+		tier = aws.StringValue(current.Tier)
+	}
+	if input.Tier != nil {
+		tier = aws.StringValue(input.Tier)
+	}
+
+	s.versions[name] = append(s.versions[name], &ssm.ParameterHistory{
+		Name:             input.Name,
+		Type:             input.Type,
+		Value:            input.Value,
+		KeyId:            input.KeyId,
+		Description:      input.Description,
+		Tier:             aws.String(tier),
+		Version:          aws.Int64(version),
+		LastModifiedDate: aws.Time(time.Now()),
+		LastModifiedUser: aws.String("arn:aws:iam::123456789012:user/ssmfake"),
+	})
+	s.tags[name] = append(s.tags[name], input.Tags...)
+
+	return &ssm.PutParameterOutput{Version: aws.Int64(version)}, nil
+}
+
+// DeleteParameter delete a parameter and its history
+func (s *SSM) DeleteParameter(input *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("DeleteParameter"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.Name)
+	if s.latest(name) == nil {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "", nil)
+	}
+
+	delete(s.versions, name)
+	delete(s.tags, name)
+
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+// DeleteParameters delete up to 10 parameters
+func (s *SSM) DeleteParameters(input *ssm.DeleteParametersInput) (*ssm.DeleteParametersOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("DeleteParameters"); err != nil {
+		return nil, err
+	}
+
+	if len(input.Names) > defaultPageSize {
+		return nil, awserr.New("ValidationException", fmt.Sprintf("Member must have length less than or equal to %d", defaultPageSize), nil)
+	}
+
+	output := &ssm.DeleteParametersOutput{}
+	for _, name := range input.Names {
+		if s.latest(*name) == nil {
+			output.InvalidParameters = append(output.InvalidParameters, name)
+			continue
+		}
+
+		delete(s.versions, *name)
+		delete(s.tags, *name)
+		output.DeletedParameters = append(output.DeletedParameters, name)
+	}
+
+	return output, nil
+}
+
+// DescribeParameters return the metadata of the parameters matching the
+// `Path` filter, 10 by 10
+func (s *SSM) DescribeParameters(input *ssm.DescribeParametersInput) (*ssm.DescribeParametersOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("DescribeParameters"); err != nil {
+		return nil, err
+	}
+
+	path := "/"
+	for _, filter := range input.ParameterFilters {
+		if aws.StringValue(filter.Key) == "Path" && len(filter.Values) > 0 {
+			path = aws.StringValue(filter.Values[0])
+		}
+	}
+
+	names := s.names(path)
+	start, end, next := page(len(names), input.NextToken, input.MaxResults)
+
+	output := &ssm.DescribeParametersOutput{NextToken: next}
+	for _, name := range names[start:end] {
+		h := s.latest(name)
+		output.Parameters = append(output.Parameters, &ssm.ParameterMetadata{
+			Name:             h.Name,
+			Type:             h.Type,
+			KeyId:            h.KeyId,
+			Description:      h.Description,
+			Tier:             h.Tier,
+			Version:          h.Version,
+			LastModifiedDate: h.LastModifiedDate,
+			LastModifiedUser: h.LastModifiedUser,
+		})
+	}
+
+	return output, nil
+}
+
+// GetParameterHistory return every version of a parameter, 10 by 10
+func (s *SSM) GetParameterHistory(input *ssm.GetParameterHistoryInput) (*ssm.GetParameterHistoryOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("GetParameterHistory"); err != nil {
+		return nil, err
+	}
+
+	versions := s.versions[aws.StringValue(input.Name)]
+	if len(versions) == 0 {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "", nil)
+	}
+
+	start, end, next := page(len(versions), input.NextToken, input.MaxResults)
+
+	return &ssm.GetParameterHistoryOutput{
+		Parameters: versions[start:end],
+		NextToken:  next,
+	}, nil
+}
+
+// ListTagsForResource return the tags of a parameter
+func (s *SSM) ListTagsForResource(input *ssm.ListTagsForResourceInput) (*ssm.ListTagsForResourceOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("ListTagsForResource"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.ResourceId)
+	if s.latest(name) == nil {
+		return nil, awserr.New(ssm.ErrCodeInvalidResourceId, "", nil)
+	}
+
+	return &ssm.ListTagsForResourceOutput{TagList: s.tags[name]}, nil
+}
+
+// AddTagsToResource add or overwrite tags of a parameter
+func (s *SSM) AddTagsToResource(input *ssm.AddTagsToResourceInput) (*ssm.AddTagsToResourceOutput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.call("AddTagsToResource"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.ResourceId)
+	if s.latest(name) == nil {
+		return nil, awserr.New(ssm.ErrCodeInvalidResourceId, "", nil)
+	}
+
+	for _, tag := range input.Tags {
+		replaced := false
+		for _, existing := range s.tags[name] {
+			if aws.StringValue(existing.Key) == aws.StringValue(tag.Key) {
+				existing.Value = tag.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.tags[name] = append(s.tags[name], tag)
+		}
+	}
+
+	return &ssm.AddTagsToResourceOutput{}, nil
+}
@@ -0,0 +1,258 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultAddress      = "https://127.0.0.1:8200"
+	defaultAppRoleMount = "approle"
+	requestTimeout      = 30 * time.Second
+)
+
+// errNotFound is returned by the requests answered with a 404
+var errNotFound = errors.New("not found")
+
+// appRoleTokens cache the tokens obtained with AppRole, by address and role
+// ID, so that a command logs in only once
+var appRoleTokens = make(map[string]string)
+var appRoleMutex sync.Mutex
+
+// client is a minimal Vault HTTP API client
+type client struct {
+	address   string
+	namespace string
+	token     string
+	http      *http.Client
+}
+
+// setting return the value of the profiler configuration key, falling back
+// on the given environment variable
+func setting(key string, env string) string {
+	if viper.GetString(key) != "" {
+		return viper.GetString(key)
+	}
+
+	return os.Getenv(env)
+}
+
+// readFile return the trimmed content of the given file
+func readFile(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func newHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: viper.GetBool("vaultSkipVerify"),
+	}
+
+	if caCert := setting("vaultCACert", "VAULT_CACERT"); caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
+	}, nil
+}
+
+// newClient build the client from the configuration (or the standard VAULT_*
+// environment variables) and authenticate it, with in order of preference:
+// vaultToken, vaultTokenFile, AppRole (vaultRoleId and vaultSecretId) and
+// the token of the Vault CLI (~/.vault-token)
+func newClient() (*client, error) {
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		address:   strings.TrimSuffix(setting("vaultAddress", "VAULT_ADDR"), "/"),
+		namespace: setting("vaultNamespace", "VAULT_NAMESPACE"),
+		token:     setting("vaultToken", "VAULT_TOKEN"),
+		http:      httpClient,
+	}
+	if c.address == "" {
+		c.address = defaultAddress
+	}
+
+	if c.token != "" {
+		return c, nil
+	}
+
+	if tokenFile := viper.GetString("vaultTokenFile"); tokenFile != "" {
+		c.token, err = readFile(tokenFile)
+		return c, err
+	}
+
+	if roleID := setting("vaultRoleId", "VAULT_ROLE_ID"); roleID != "" {
+		c.token, err = c.appRoleLogin(roleID)
+		return c, err
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if token, err := readFile(home + "/.vault-token"); err == nil {
+			c.token = token
+		}
+	}
+
+	return c, nil
+}
+
+// appRoleLogin return a token for the given AppRole
+func (c *client) appRoleLogin(roleID string) (string, error) {
+	appRoleMutex.Lock()
+	defer appRoleMutex.Unlock()
+
+	cacheKey := c.address + "|" + roleID
+	if token, ok := appRoleTokens[cacheKey]; ok {
+		return token, nil
+	}
+
+	secretID := setting("vaultSecretId", "VAULT_SECRET_ID")
+	if secretIDFile := viper.GetString("vaultSecretIdFile"); secretIDFile != "" {
+		var err error
+		secretID, err = readFile(secretIDFile)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	mount := viper.GetString("vaultAppRoleMount")
+	if mount == "" {
+		mount = defaultAppRoleMount
+	}
+
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err := c.request(http.MethodPost, fmt.Sprintf("auth/%s/login", mount), map[string]string{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("AppRole login failed: %w", err)
+	}
+
+	appRoleTokens[cacheKey] = resp.Auth.ClientToken
+
+	return resp.Auth.ClientToken, nil
+}
+
+// request call the Vault API, encoding body and decoding the response in out
+// when they are set
+func (c *client) request(method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Errors:     apiErr.Errors,
+		}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+/*APIError is an error returned by the Vault API*/
+type APIError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("vault: %d %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+/*Source describe where the Vault profiles are read from: the address, namespace, mount and path*/
+func Source() string {
+	return strings.Join([]string{
+		strings.TrimSuffix(setting("vaultAddress", "VAULT_ADDR"), "/"),
+		setting("vaultNamespace", "VAULT_NAMESPACE"),
+		mount(),
+		basePath(),
+	}, " ")
+}
+
+/*Configured tell if a Vault server is configured, with `vaultAddress` or the VAULT_ADDR environment variable*/
+func Configured() bool {
+	return setting("vaultAddress", "VAULT_ADDR") != ""
+}
@@ -0,0 +1,438 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMount = "secret"
+	defaultPath  = "profiler"
+
+	maxCASAttempts = 5
+	casRetryDelay  = 100 * time.Millisecond
+)
+
+// validName match the profile names, possibly in sub folders, keeping the
+// requests within the profiles path
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(/[A-Za-z0-9_][A-Za-z0-9_.-]*)*$`)
+
+/*ErrConflict is returned when a profile keeps being modified concurrently*/
+var ErrConflict = errors.New("the profile has been concurrently modified, please retry")
+
+/*Version is a version of a profile*/
+type Version struct {
+	Version     int
+	CreatedTime time.Time
+	Deleted     bool
+	Destroyed   bool
+}
+
+// secret is the data of a KV v2 secret version
+type secret struct {
+	Data struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+// mount return the KV v2 secrets engine mount (`vaultMount`)
+func mount() string {
+	if viper.GetString("vaultMount") != "" {
+		return strings.Trim(viper.GetString("vaultMount"), "/")
+	}
+
+	return defaultMount
+}
+
+// basePath return the path of the profiles in the mount (`vaultPath`)
+func basePath() string {
+	if viper.GetString("vaultPath") != "" {
+		return strings.Trim(viper.GetString("vaultPath"), "/")
+	}
+
+	return defaultPath
+}
+
+// checkName return an error when the profile name would leave the profiles
+// path or alter the request
+func checkName(profileName string) error {
+	if !validName.MatchString(profileName) {
+		return fmt.Errorf("invalid profile name %s", profileName)
+	}
+
+	return nil
+}
+
+func dataPath(profileName string) string {
+	return fmt.Sprintf("%s/data/%s/%s", mount(), basePath(), profileName)
+}
+
+func metadataPath(profileName string) string {
+	return strings.TrimSuffix(fmt.Sprintf("%s/metadata/%s/%s", mount(), basePath(), profileName), "/")
+}
+
+// readProfile return the variables and version of the profile, the given
+// version or the current one when version is 0
+func readProfile(c *client, profileName string, version int) (map[string]string, int, error) {
+	path := dataPath(profileName)
+	if version > 0 {
+		path += "?version=" + strconv.Itoa(version)
+	}
+
+	var s secret
+	err := c.request(http.MethodGet, path, nil, &s)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	vars := make(map[string]string)
+	for k, v := range s.Data.Data {
+		vars[k], err = stringValue(v)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return vars, s.Data.Metadata.Version, nil
+}
+
+// stringValue convert a secret value to a variable value: the secrets written
+// by other tools may hold numbers, booleans or objects, kept as their JSON
+func stringValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// currentVersion return the latest version of the profile from its metadata,
+// which remains when this version has been deleted, 0 if it doesn't exist
+func currentVersion(c *client, profileName string) (int, error) {
+	var resp struct {
+		Data struct {
+			CurrentVersion int `json:"current_version"`
+		} `json:"data"`
+	}
+
+	err := c.request(http.MethodGet, metadataPath(profileName), nil, &resp)
+	if errors.Is(err, errNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Data.CurrentVersion, nil
+}
+
+/*ProfileExist return a boolean representation of the given profile existence*/
+func ProfileExist(profileName string) (bool, error) {
+	if err := checkName(profileName); err != nil {
+		return false, err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return false, err
+	}
+
+	_, _, err = readProfile(c, profileName, 0)
+	if errors.Is(err, errNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// listProfiles return the profiles found under the given folder, walking
+// down the sub folders
+func listProfiles(c *client, folder string) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+
+	err := c.request("LIST", metadataPath(folder), nil, &resp)
+	if errors.Is(err, errNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return []string{}, err
+	}
+
+	var profiles []string
+	for _, key := range resp.Data.Keys {
+		if !strings.HasSuffix(key, "/") {
+			profiles = append(profiles, folder+key)
+			continue
+		}
+
+		subProfiles, err := listProfiles(c, folder+key)
+		if err != nil {
+			return []string{}, err
+		}
+		profiles = append(profiles, subProfiles...)
+	}
+
+	return profiles, nil
+}
+
+/*ListProfiles return the name of the Vault profiles as []string*/
+func ListProfiles() ([]string, error) {
+	c, err := newClient()
+	if err != nil {
+		return []string{}, err
+	}
+
+	profiles, err := listProfiles(c, "")
+	if err != nil {
+		return []string{}, err
+	}
+	sort.Strings(profiles)
+
+	return profiles, nil
+}
+
+/*GetProfile retrieve the given version of the profile variables from Vault (the current one when version is 0)*/
+func GetProfile(profileName string, version int) (map[string]string, error) {
+	if err := checkName(profileName); err != nil {
+		return map[string]string{}, err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	vars, _, err := readProfile(c, profileName, version)
+	if errors.Is(err, errNotFound) && version > 0 {
+		return map[string]string{}, fmt.Errorf("version %d of the %s profile not found", version, profileName)
+	}
+	if errors.Is(err, errNotFound) {
+		return map[string]string{}, fmt.Errorf("profile %s not found", profileName)
+	}
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return vars, nil
+}
+
+/*ShowProfile return the list of keys of the given version of the profile*/
+func ShowProfile(profileName string, version int) ([]string, error) {
+	vars, err := GetProfile(profileName, version)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// isCASMismatch tell if the write has been refused because the profile
+// version changed since it has been read
+func isCASMismatch(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+
+	for _, e := range apiErr.Errors {
+		if strings.Contains(e, "check-and-set") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateProfile apply fn to the variables of the profile (created if it
+// doesn't exist yet) and write them as a new version, with a check-and-set on
+// the version read, retrying when the profile has been concurrently modified
+func updateProfile(profileName string, fn func(vars map[string]string) error) error {
+	if err := checkName(profileName); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * casRetryDelay)
+		}
+
+		vars, version, err := readProfile(c, profileName, 0)
+		if errors.Is(err, errNotFound) {
+			// The latest version may be deleted rather than missing, the
+			// check-and-set needs its number:
+			vars = map[string]string{"profile_name": profileName}
+			version, err = currentVersion(c, profileName)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(vars)
+		if err != nil {
+			return err
+		}
+
+		// A zero cas only writes the secret if it doesn't exist yet:
+		err = c.request(http.MethodPost, dataPath(profileName), map[string]interface{}{
+			"data":    vars,
+			"options": map[string]int{"cas": version},
+		}, nil)
+		if isCASMismatch(err) {
+			continue
+		}
+
+		return err
+	}
+
+	return ErrConflict
+}
+
+/*AddKVPair add one or more KV pairs to the given profile identified by profileName*/
+func AddKVPair(profileName string, KVs []string) error {
+	if len(KVs)%2 != 0 {
+		return errors.New("Missing value for the variable " + KVs[len(KVs)-1])
+	}
+
+	return updateProfile(profileName, func(vars map[string]string) error {
+		for i := 0; i < len(KVs); i += 2 {
+			vars[KVs[i]] = KVs[i+1]
+		}
+
+		return nil
+	})
+}
+
+/*RemoveKVPair remove the given variable from the profile*/
+func RemoveKVPair(profileName string, key string) error {
+	return updateProfile(profileName, func(vars map[string]string) error {
+		if _, ok := vars[key]; !ok {
+			return fmt.Errorf("%s not found in the %s profile", key, profileName)
+		}
+
+		delete(vars, key)
+
+		return nil
+	})
+}
+
+/*DeleteProfile delete the given profile with all its versions*/
+func DeleteProfile(profileName string) error {
+	if err := checkName(profileName); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	return c.request(http.MethodDelete, metadataPath(profileName), nil, nil)
+}
+
+/*History return the versions of the given profile, oldest first*/
+func History(profileName string) ([]Version, error) {
+	if err := checkName(profileName); err != nil {
+		return nil, err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data struct {
+			Versions map[string]struct {
+				CreatedTime  time.Time `json:"created_time"`
+				DeletionTime string    `json:"deletion_time"`
+				Destroyed    bool      `json:"destroyed"`
+			} `json:"versions"`
+		} `json:"data"`
+	}
+
+	err = c.request(http.MethodGet, metadataPath(profileName), nil, &resp)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("profile %s not found", profileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for v, info := range resp.Data.Versions {
+		number, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, Version{
+			Version:     number,
+			CreatedTime: info.CreatedTime,
+			Deleted:     info.DeletionTime != "",
+			Destroyed:   info.Destroyed,
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+/*Rollback write the variables of the given version of the profile as its new version*/
+func Rollback(profileName string, version int) error {
+	vars, err := GetProfile(profileName, version)
+	if err != nil {
+		return err
+	}
+
+	return updateProfile(profileName, func(current map[string]string) error {
+		for k := range current {
+			delete(current, k)
+		}
+		for k, v := range vars {
+			current[k] = v
+		}
+
+		return nil
+	})
+}
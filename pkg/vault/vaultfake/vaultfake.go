@@ -0,0 +1,283 @@
+// Package vaultfake provides an in-memory Vault server exposing the KV v2
+// secrets engine and the AppRole login, used to test the Vault backend offline.
+package vaultfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version is a version of a secret
+type version struct {
+	data        map[string]interface{}
+	createdTime time.Time
+	deletedTime time.Time
+}
+
+// Server is an in-memory Vault server. Every KV v2 mount is accepted.
+type Server struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	secrets map[string][]version
+
+	// Token is the token expected by the KV requests
+	Token string
+	// RoleID and SecretID are the AppRole credentials exchanged for Token
+	RoleID   string
+	SecretID string
+	// Namespace, when set, is the namespace expected by every request
+	Namespace string
+
+	// Logins count the AppRole logins
+	Logins int
+}
+
+// New start a server accepting the given token, to be closed by the caller
+func New(token string) *Server {
+	s := &Server{
+		secrets: make(map[string][]version),
+		Token:   token,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// Put write a new version of the secret at the given path of the mount
+// (e.g. "secret", "profiler/dev")
+func (s *Server) Put(mount string, path string, data map[string]string) {
+	values := make(map[string]interface{})
+	for k, v := range data {
+		values[k] = v
+	}
+
+	s.PutData(mount, path, values)
+}
+
+// PutData write a new version of the secret with values of any JSON type,
+// like the secrets written by other tools than Profiler
+func (s *Server) PutData(mount string, path string, data map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(mount+"/"+path, data)
+}
+
+// Delete soft delete the latest version of the secret, like `vault kv delete`
+func (s *Server) Delete(mount string, path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.softDelete(mount + "/" + path)
+}
+
+// softDelete mark the latest version of the secret as deleted, the caller
+// holding the lock
+func (s *Server) softDelete(key string) {
+	if versions := s.secrets[key]; len(versions) > 0 {
+		versions[len(versions)-1].deletedTime = time.Now().UTC()
+	}
+}
+
+// put add a version to the secret, the caller holding the lock
+func (s *Server) put(key string, data map[string]interface{}) int {
+	s.secrets[key] = append(s.secrets[key], version{
+		data:        data,
+		createdTime: time.Now().UTC(),
+	})
+
+	return len(s.secrets[key])
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errs ...string) {
+	writeJSON(w, status, map[string][]string{"errors": errs})
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Namespace != "" && r.Header.Get("X-Vault-Namespace") != s.Namespace {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(path, "auth/approle/login") {
+		s.login(w, r)
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != s.Token {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 3 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	key := parts[0] + "/" + strings.TrimSuffix(parts[2], "/")
+
+	switch {
+	case parts[1] == "data" && r.Method == http.MethodGet:
+		s.read(w, r, key)
+	case parts[1] == "data" && r.Method == http.MethodPost:
+		s.write(w, r, key)
+	case parts[1] == "data" && r.Method == http.MethodDelete:
+		s.softDelete(key)
+		w.WriteHeader(http.StatusNoContent)
+	case parts[1] == "metadata" && (r.Method == "LIST" || r.URL.Query().Get("list") == "true"):
+		s.list(w, key)
+	case parts[1] == "metadata" && r.Method == http.MethodGet:
+		s.metadata(w, key)
+	case parts[1] == "metadata" && r.Method == http.MethodDelete:
+		delete(s.secrets, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var creds struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&creds)
+
+	if s.RoleID == "" || creds.RoleID != s.RoleID || creds.SecretID != s.SecretID {
+		writeErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+
+	s.Logins++
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]string{"client_token": s.Token},
+	})
+}
+
+func (s *Server) read(w http.ResponseWriter, r *http.Request, key string) {
+	versions := s.secrets[key]
+	if len(versions) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	number := len(versions)
+	if r.URL.Query().Get("version") != "" {
+		number, _ = strconv.Atoi(r.URL.Query().Get("version"))
+	}
+	if number < 1 || number > len(versions) || !versions[number-1].deletedTime.IsZero() {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"data": versions[number-1].data,
+			"metadata": map[string]interface{}{
+				"version":      number,
+				"created_time": versions[number-1].createdTime,
+			},
+		},
+	})
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, key string) {
+	var body struct {
+		Data    map[string]interface{} `json:"data"`
+		Options struct {
+			CAS *int `json:"cas"`
+		} `json:"options"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Options.CAS != nil && *body.Options.CAS != len(s.secrets[key]) {
+		writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		return
+	}
+
+	number := s.put(key, body.Data)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{"version": number},
+	})
+}
+
+func (s *Server) list(w http.ResponseWriter, folder string) {
+	prefix := folder + "/"
+	found := make(map[string]bool)
+	for key := range s.secrets {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+		}
+		found[name] = true
+	}
+
+	if len(found) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	var keys []string
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{"keys": keys},
+	})
+}
+
+func (s *Server) metadata(w http.ResponseWriter, key string) {
+	versions := s.secrets[key]
+	if len(versions) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	infos := make(map[string]interface{})
+	for i, v := range versions {
+		var deletionTime string
+		if !v.deletedTime.IsZero() {
+			deletionTime = v.deletedTime.Format(time.RFC3339Nano)
+		}
+		infos[fmt.Sprint(i+1)] = map[string]interface{}{
+			"created_time":  v.createdTime,
+			"deletion_time": deletionTime,
+			"destroyed":     false,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"current_version": len(versions),
+			"versions":        infos,
+		},
+	})
+}
@@ -0,0 +1,148 @@
+// Package yamlfile edits the variables of YAML profile files in place,
+// keeping their comments and the order of the variables.
+package yamlfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/julienlevasseur/profiler/pkg/meta"
+)
+
+// readDocument return the mapping of the profile file, a new profile when the
+// file doesn't exist. Editing the document rather than a map keeps the
+// comments and the order of the variables, for readable diffs.
+func readDocument(file string, name string) (*yaml.Node, *yaml.Node, error) {
+	source, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		source = []byte(fmt.Sprintf("profile_name: %s\n", name))
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(source, &doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("%s is not a YAML profile", file)
+	}
+
+	return &doc, doc.Content[0], nil
+}
+
+func writeDocument(file string, doc *yaml.Node) error {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+
+	err := encoder.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, b.Bytes(), 0644)
+}
+
+/*SetVariable set the variable of the given profile file, created if needed*/
+func SetVariable(file string, name string, key string, value string) error {
+	doc, mapping, err := readDocument(file, name)
+	if err != nil {
+		return err
+	}
+
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+
+	found := false
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = valueNode
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		mapping.Content = append(
+			mapping.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			valueNode,
+		)
+	}
+
+	return writeDocument(file, doc)
+}
+
+/*RemoveVariable remove the variable from the given profile file*/
+func RemoveVariable(file string, name string, key string) error {
+	doc, mapping, err := readDocument(file, name)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+
+			return writeDocument(file, doc)
+		}
+	}
+
+	return fmt.Errorf("%s not found in the %s profile", key, name)
+}
+
+/*Variables return the variables of the given profile file, without its metadata*/
+func Variables(file string) (map[string]string, error) {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes map[string]yaml.Node
+	err = yaml.Unmarshal(source, &nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string)
+	for k, node := range nodes {
+		if k == meta.Key {
+			continue
+		}
+
+		var v string
+		err = node.Decode(&v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		vars[k] = v
+	}
+
+	return vars, nil
+}
+
+/*Meta return the metadata of the given profile file*/
+func Meta(file string) (meta.Meta, error) {
+	var profile struct {
+		Meta meta.Meta `yaml:"_meta"`
+	}
+
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return profile.Meta, err
+	}
+
+	err = yaml.Unmarshal(source, &profile)
+	if err != nil {
+		return profile.Meta, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return profile.Meta, nil
+}